@@ -0,0 +1,86 @@
+package cicd
+
+import (
+	"fmt"
+	"os"
+)
+
+// acrTokenUser is the username ACR expects alongside an Azure AD access or refresh token
+const acrTokenUser = "00000000-0000-0000-0000-000000000000"
+
+// ACR is an Azure Container Registry repository.  Credentials come from environment variables, in order of
+// preference: a service principal (AZURE_CLIENT_ID, AZURE_CLIENT_SECRET), a repository-scoped token
+// (ACR_TOKEN_NAME, ACR_TOKEN_PASSWORD) or an Azure AD token (ACR_ACCESS_TOKEN), unless another source
+// is declared under Credentials
+type ACR struct {
+	Name        string
+	Description string
+	Registry    string
+	Repo        string
+	Url         string
+	Credentials Credentials
+
+	registryRuntime
+}
+
+// GetRepoURL returns the configured url, or the one implied by registry and repo
+func (r *ACR) GetRepoURL() (repoURL string) {
+	if r.Url != "" {
+		return r.Url
+	}
+	if r.Registry == "" || r.Repo == "" {
+		return ""
+	}
+	return fmt.Sprintf("%v.azurecr.io/%v", r.Registry, r.Repo)
+}
+
+func (r *ACR) ListTags() ([]string, error) {
+	return listTags(r.client, r.GetRepoURL())
+}
+
+func (r *ACR) IsRegistryValid() (err error) {
+	if r.GetRepoURL() == "" {
+		err = fmt.Errorf("registry and repo, or url, missing from %v configuration", r.Description)
+	} else {
+		err = validRepoURL(r.Description, r.GetRepoURL())
+	}
+	return err
+}
+
+func (r *ACR) Authenticate() (err error) {
+	host, _ := splitRepoURL(r.GetRepoURL())
+
+	var user, pass string
+	if r.Credentials.Source == "" {
+		if user, pass, err = acrCredentials(); err == nil {
+			err = dockerLogin(r.executor, host, user, pass)
+		}
+	} else {
+		user, pass, err = r.Credentials.Login(r.executor, host, "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET")
+	}
+	if err != nil {
+		return err
+	}
+
+	// registry api client used to compare remote digests before pushing
+	r.newClient(host, user, pass)
+
+	return err
+}
+
+func (r *ACR) Push(images []string) (result PushResult, err error) {
+	return r.dockerPush(r.GetRepoURL(), images)
+}
+
+func acrCredentials() (user string, pass string, err error) {
+	switch {
+	case os.Getenv("AZURE_CLIENT_ID") != "" && os.Getenv("AZURE_CLIENT_SECRET") != "":
+		return os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET"), err
+	case os.Getenv("ACR_TOKEN_NAME") != "" && os.Getenv("ACR_TOKEN_PASSWORD") != "":
+		return os.Getenv("ACR_TOKEN_NAME"), os.Getenv("ACR_TOKEN_PASSWORD"), err
+	case os.Getenv("ACR_ACCESS_TOKEN") != "":
+		return acrTokenUser, os.Getenv("ACR_ACCESS_TOKEN"), err
+	}
+	err = fmt.Errorf("acr credentials not set: use AZURE_CLIENT_ID/AZURE_CLIENT_SECRET, ACR_TOKEN_NAME/ACR_TOKEN_PASSWORD or ACR_ACCESS_TOKEN")
+	return user, pass, err
+}
@@ -0,0 +1,81 @@
+package cicd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestACRCredentials(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		user, pass string
+		err        bool
+	}{
+		{"service principal", map[string]string{"AZURE_CLIENT_ID": "sp", "AZURE_CLIENT_SECRET": "sp-secret", "ACR_ACCESS_TOKEN": "aad"}, "sp", "sp-secret", false},
+		{"repository token", map[string]string{"ACR_TOKEN_NAME": "pusher", "ACR_TOKEN_PASSWORD": "token-secret", "ACR_ACCESS_TOKEN": "aad"}, "pusher", "token-secret", false},
+		{"azure ad token", map[string]string{"AZURE_CLIENT_ID": "sp", "ACR_ACCESS_TOKEN": "aad"}, acrTokenUser, "aad", false},
+		{"none", map[string]string{"ACR_TOKEN_NAME": "pusher"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, env := range []string{"AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "ACR_TOKEN_NAME", "ACR_TOKEN_PASSWORD", "ACR_ACCESS_TOKEN"} {
+				t.Setenv(env, tt.env[env])
+			}
+
+			user, pass, err := acrCredentials()
+			if (err != nil) != tt.err {
+				t.Fatalf("acrCredentials error = %v", err)
+			}
+			if user != tt.user || pass != tt.pass {
+				t.Errorf("acrCredentials = %v, %v, want %v, %v", user, pass, tt.user, tt.pass)
+			}
+		})
+	}
+}
+
+func TestACR(t *testing.T) {
+	t.Setenv("AZURE_CLIENT_ID", "sp")
+	t.Setenv("AZURE_CLIENT_SECRET", "sp-secret")
+	const image = "myregistry.azurecr.io/team/app:abc123"
+
+	r := &ACR{Description: "acr", Registry: "myregistry", Repo: "team/app"}
+	if err := r.IsRegistryValid(); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.GetRepoURL(); got != "myregistry.azurecr.io/team/app" {
+		t.Errorf("GetRepoURL = %v", got)
+	}
+
+	e := NewReplayExecutor([]Recording{
+		dockerRecording("", "", "login", "-u", "sp", "--password-stdin", "myregistry.azurecr.io"),
+		dockerRecording("", "Error: No such image", "image", "inspect", "--format", "{{.Id}}", image),
+		dockerRecording("abc123: digest: "+testDigest1+" size: 528\n", "", "push", image),
+	})
+	r.SetExecutor(e)
+
+	if err := r.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+	if c := r.Client(); c.Username != "sp" || c.Password != "sp-secret" {
+		t.Errorf("client credentials = %v, %v", c.Username, c.Password)
+	}
+	result, err := r.Push([]string{image})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Pushed) != 1 || result.Pushed[0].Digest != testDigest1 {
+		t.Errorf("pushed %+v", result)
+	}
+	if remaining := e.Remaining(); len(remaining) > 0 {
+		t.Errorf("commands not run: %v", remaining)
+	}
+
+	// a failed login stops authentication
+	r.SetExecutor(NewReplayExecutor([]Recording{
+		dockerRecording("", "unauthorized: invalid client secret", "login", "-u", "sp", "--password-stdin", "myregistry.azurecr.io"),
+	}))
+	if err = r.Authenticate(); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("Authenticate error = %v", err)
+	}
+}
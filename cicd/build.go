@@ -0,0 +1,368 @@
+package cicd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// AppBuild declares how the application image is built.  Context defaults to the current directory
+// and Dockerfile to the Dockerfile in Context.  Args values are text/template strings rendered with the
+// tag template variables, e.g. VERSION: "{{.Semver}}"
+type AppBuild struct {
+	Dockerfile string
+	Context    string
+	Args       map[string]string
+	Cache      BuildCache
+}
+
+// BuildCache reuses build cache through the active registry's repository, importing it from the
+// branch and latest tags.  Mode is one of:
+//
+//	none     no cache (default)
+//	inline   pull the branch and latest images as cache-from and embed cache metadata in the built
+//	         image, so pushing the tags exports the cache
+//	registry build with buildx, importing from separate <tag>-buildcache tags.  The build saves its
+//	         cache locally and push exports it to the branch cache tag of the active registry; Max
+//	         saves cache for all intermediate layers rather than the final stage only
+//
+// Registry mode needs a buildx builder with the docker-container driver, as the default docker driver
+// cannot export cache.  Builder names it; the default gocloud-cicd builder is created when missing
+type BuildCache struct {
+	Mode    string
+	Max     bool
+	Builder string
+}
+
+const (
+	// buildCacheSuffix names the registry mode cache tag for an image tag
+	buildCacheSuffix = "-buildcache"
+
+	defaultBuildxBuilder = "gocloud-cicd"
+)
+
+// OCI annotation keys stamped on built images; branch has no standard key
+const (
+	labelSource   = "org.opencontainers.image.source"
+	labelRevision = "org.opencontainers.image.revision"
+	labelCreated  = "org.opencontainers.image.created"
+	labelVersion  = "org.opencontainers.image.version"
+	labelTitle    = "org.opencontainers.image.title"
+	labelBranch   = "cicd.branch"
+)
+
+// buildTime is replaced in tests
+var buildTime = time.Now
+
+// Build builds the application image declared in cicd.yaml, labeled with its source, revision, creation
+// time, branch and version.  The image is tagged --image, by default <app name>:<commit>, and is
+// returned for use as the --image of a following push
+func (wf *Workflow) Build() (image string, err error) {
+	opts := wf.Options
+
+	// detect build context from CI, then validate options
+	if _, err = wf.applyBuildInfo(&opts); err != nil {
+		return image, err
+	}
+	if image, err = wf.buildImage(opts); err != nil {
+		return image, err
+	}
+
+	var args []string
+	if args, err = wf.buildArgs(opts); err != nil {
+		return image, err
+	}
+
+	build := wf.App.Build
+	context := build.Context
+	if context == "" {
+		context = "."
+	}
+
+	var cacheArgs []string
+	var builder string
+	if cacheArgs, builder, err = wf.buildCache(opts, image); err != nil {
+		return image, err
+	}
+
+	cmdArgs := []string{"build", "-t", image}
+	if builder != "" {
+		cmdArgs = append([]string{"buildx", "build", "--builder", builder, "--load"}, cmdArgs[1:]...)
+	}
+	cmdArgs = append(cmdArgs, cacheArgs...)
+	if build.Dockerfile != "" {
+		cmdArgs = append(cmdArgs, "-f", filepath.Clean(build.Dockerfile))
+	}
+	for _, a := range args {
+		cmdArgs = append(cmdArgs, "--build-arg", a)
+	}
+	for _, l := range wf.buildLabels(opts) {
+		cmdArgs = append(cmdArgs, "--label", l)
+	}
+	cmdArgs = append(cmdArgs, context)
+
+	if _, err = wf.Executor().Execute(Command{Name: "docker", Args: cmdArgs}); err != nil {
+		return image, err
+	}
+	log.Println("built image:", image)
+	return image, err
+}
+
+// buildCache returns the build options importing and saving cache for the configured mode, and the
+// buildx builder they require.  The cache is an optimization: when the active registry is unavailable
+// the build proceeds without it
+func (wf *Workflow) buildCache(opts Options, image string) (args []string, builder string, err error) {
+	cache := wf.App.Build.Cache
+
+	switch cache.Mode {
+	case "", "none":
+		return args, builder, err
+	case "inline", "registry":
+	default:
+		return args, builder, fmt.Errorf("unknown build cache mode: <%v>", cache.Mode)
+	}
+
+	var repo Reference
+	if repo, err = wf.cacheRepository(); err != nil {
+		log.Println("build cache disabled:", err)
+		return args, builder, nil
+	}
+
+	// import from the branch tag and latest
+	if cache.Mode == "inline" {
+		for _, tag := range cacheTags(opts) {
+			ref := repo.WithTag(tag).String()
+			if _, perr := wf.Executor().Execute(Command{Name: "docker", Args: []string{"pull", ref}}); perr != nil {
+				log.Println("build cache: no image", ref)
+				continue
+			}
+			args = append(args, "--cache-from", ref)
+		}
+		args = append(args, "--build-arg", "BUILDKIT_INLINE_CACHE=1")
+		return args, builder, err
+	}
+
+	if builder, err = wf.buildxBuilder(); err != nil {
+		return args, builder, err
+	}
+	for _, tag := range cacheTags(opts) {
+		args = append(args, "--cache-from", "type=registry,ref="+repo.WithTag(buildCacheTag(tag)).String())
+	}
+
+	// push exports the saved cache as an image manifest, which every registry accepts
+	dir := buildCacheDir(image)
+	if !wf.IsDryRun() {
+		if err = os.RemoveAll(dir); err != nil {
+			return args, builder, err
+		}
+	}
+	mode := "min"
+	if cache.Max {
+		mode = "max"
+	}
+	args = append(args, "--cache-to", fmt.Sprintf("type=local,dest=%v,mode=%v,oci-mediatypes=true,image-manifest=true", dir, mode))
+	return args, builder, err
+}
+
+// buildxBuilder returns the builder for registry mode cache, creating the default builder when missing
+func (wf *Workflow) buildxBuilder() (builder string, err error) {
+	if builder = wf.App.Build.Cache.Builder; builder == "" {
+		builder = defaultBuildxBuilder
+	}
+
+	res, err := wf.Executor().Execute(Command{Name: "docker", Args: []string{"buildx", "inspect", builder}, Always: true, Quiet: true})
+	if err != nil {
+		if builder != defaultBuildxBuilder {
+			return builder, fmt.Errorf("build cache: buildx builder %v: %v", builder, strings.TrimSpace(err.Error()))
+		}
+		_, err = wf.Executor().Execute(Command{Name: "docker", Args: []string{"buildx", "create", "--name", builder, "--driver", "docker-container"}})
+		return builder, err
+	}
+
+	for _, line := range strings.Split(string(res.Stdout), "\n") {
+		if strings.HasPrefix(line, "Driver:") && strings.TrimSpace(strings.TrimPrefix(line, "Driver:")) == "docker" {
+			return builder, fmt.Errorf("build cache: buildx builder %v uses the docker driver, which cannot export cache; use a docker-container builder", builder)
+		}
+	}
+	return builder, err
+}
+
+// cacheTags returns the tags build cache is imported from, the branch tag first
+func cacheTags(opts Options) (tags []string) {
+	tags = []string{"latest"}
+	if opts.Branch != "" {
+		if branch := SanitizeTag(opts.Branch); branch != "latest" {
+			tags = append([]string{branch}, tags...)
+		}
+	}
+	return tags
+}
+
+// buildCacheTag returns the registry mode cache tag of an image tag, shortened with a hash when needed so
+// the suffix fits within the tag length limit and cleanup still recognizes it
+func buildCacheTag(tag string) string {
+	return withHash(tag, tag, false, maxTagLength-len(buildCacheSuffix), "") + buildCacheSuffix
+}
+
+// buildCacheDir is where a registry mode build saves the cache of image for push to export
+func buildCacheDir(image string) string {
+	return filepath.Join(os.TempDir(), "gocloud-cicd-buildcache", SanitizeTag(image))
+}
+
+// exportBuildCache uploads the cache saved by the build of the pushed image to the branch cache tag of
+// the registry.  The cache is an optimization, so failures are logged and do not fail the push
+func (wf *Workflow) exportBuildCache(ar Registrator, opts Options) {
+	if err := wf.pushBuildCache(ar, opts); err != nil {
+		log.Println("build cache not exported:", err)
+	}
+}
+
+func (wf *Workflow) pushBuildCache(ar Registrator, opts Options) (err error) {
+	dir := buildCacheDir(opts.Image)
+	repo, err := ParseRepository(ar.GetRepoURL())
+	if err != nil {
+		return err
+	}
+	ref := repo.WithTag(buildCacheTag(cacheTags(opts)[0]))
+
+	if wf.IsDryRun() {
+		log.Println("dryrun: export build cache", dir, "to", ref)
+		return err
+	}
+	if !fileExists(filepath.Join(dir, "oci-layout")) {
+		return fmt.Errorf("no build cache saved for %v in %v", opts.Image, dir)
+	}
+	api, ok := ar.(RegistryAPI)
+	if !ok || api.Client() == nil {
+		return fmt.Errorf("%v: registry has no distribution API client", ar.GetRepoURL())
+	}
+	c := api.Client()
+
+	li, err := OpenLocalImage(dir)
+	if err != nil {
+		return err
+	}
+	defer li.Close()
+
+	for _, d := range li.Blobs {
+		var exists bool
+		if exists, err = c.BlobExists(ref.APIPath(), d); err != nil {
+			return err
+		}
+		if !exists {
+			if err = c.UploadBlob(ref.APIPath(), d, func() (io.ReadCloser, error) { return li.Open(d) }); err != nil {
+				return err
+			}
+		}
+	}
+
+	var digest string
+	if digest, err = c.PutManifest(ref.APIPath(), ref.Tag, li.MediaType, li.Manifest); err != nil {
+		return err
+	}
+	log.Println("exported build cache:", ref, digest)
+	return err
+}
+
+// cacheRepository authenticates with the active registry and returns its repository
+func (wf *Workflow) cacheRepository() (repo Reference, err error) {
+	var activeRegistry interface{}
+	if activeRegistry, err = wf.GetActiveRegistry(); err != nil {
+		return repo, err
+	}
+	ar := activeRegistry.(Registrator)
+
+	if _, ok := ar.(SourcePusher); ok {
+		return repo, fmt.Errorf("%v: registry pushes without the docker daemon", ar.GetRepoURL())
+	}
+	if err = ar.IsRegistryValid(); err != nil {
+		return repo, err
+	}
+
+	wf.loginMu.Lock()
+	err = ar.Authenticate()
+	wf.loginMu.Unlock()
+	if err != nil {
+		return repo, err
+	}
+	return ParseRepository(ar.GetRepoURL())
+}
+
+// buildImage returns the image name to build, defaulting to <app name>:<commit>
+func (wf *Workflow) buildImage(opts Options) (image string, err error) {
+	switch image = opts.Image; {
+	case image != "":
+	case wf.App.Name == "":
+		return image, fmt.Errorf("%v", "build image name required; use --image option or set app name in cicd.yaml")
+	case opts.Commit == "":
+		return image, fmt.Errorf("%v", "build commit unknown; use --commit or --image option")
+	default:
+		image = strings.ToLower(wf.App.Name) + ":" + SanitizeTag(opts.Commit)
+	}
+
+	if err = imageError(image); err != nil {
+		return image, err
+	}
+	return image, err
+}
+
+// buildArgs renders the configured build args, overridden by --build-arg options, in key order
+func (wf *Workflow) buildArgs(opts Options) (args []string, err error) {
+	values := map[string]string{}
+
+	vars := tagVars(opts, opts.Commit)
+	for k, v := range wf.App.Build.Args {
+		var t *template.Template
+		if t, err = template.New(k).Option("missingkey=error").Parse(v); err != nil {
+			return args, fmt.Errorf("build arg %v: %v", k, err)
+		}
+		var out bytes.Buffer
+		if err = t.Execute(&out, vars); err != nil {
+			return args, fmt.Errorf("build arg %v: %v", k, err)
+		}
+		values[k] = out.String()
+	}
+
+	for _, a := range opts.BuildArgs {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return args, fmt.Errorf("build arg %q must be KEY=VALUE", a)
+		}
+		values[kv[0]] = kv[1]
+	}
+
+	for k, v := range values {
+		args = append(args, k+"="+v)
+	}
+	sort.Strings(args)
+	return args, err
+}
+
+// buildLabels returns the traceability labels for the build, omitting unknown values
+func (wf *Workflow) buildLabels(opts Options) (labels []string) {
+	source := wf.App.Repo
+	if source != "" && !strings.Contains(source, "://") {
+		source = "https://" + source
+	}
+
+	for _, l := range []struct{ key, value string }{
+		{labelTitle, wf.App.Name},
+		{labelSource, source},
+		{labelRevision, opts.Commit},
+		{labelCreated, buildTime().UTC().Format(time.RFC3339)},
+		{labelBranch, opts.Branch},
+		{labelVersion, opts.Version},
+	} {
+		if l.value != "" {
+			labels = append(labels, l.key+"="+l.value)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,237 @@
+package cicd
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newBuildWorkflow returns a workflow building app:abc123 on feature/x, with the docker registry of
+// newPushWorkflow as the active registry and a fixed build time
+func newBuildWorkflow(t *testing.T) *Workflow {
+	t.Helper()
+	buildTime = func() time.Time { return time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC) }
+	t.Cleanup(func() { buildTime = time.Now })
+
+	wf := newPushWorkflow(t)
+	wf.App.Name = "app"
+	wf.Options = Options{Commit: "abc123", Branch: "feature/x", Event: "push"}
+	return wf
+}
+
+// buildRecording is the docker build of app:abc123 on feature/x with the given leading and cache args
+func buildRecording(err string, leading []string, cache ...string) Recording {
+	args := append(append(append([]string{}, leading...), "-t", "app:abc123"), cache...)
+	args = append(args,
+		"--label", labelTitle+"=app",
+		"--label", labelRevision+"=abc123",
+		"--label", labelCreated+"=2024-06-30T12:00:00Z",
+		"--label", labelBranch+"=feature/x",
+		".")
+	return dockerRecording("", err, args...)
+}
+
+func TestBuildCache(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	const repo = "registry.example.com/team/app"
+	branch := SanitizeTag("feature/x")
+	dir := buildCacheDir("app:abc123")
+
+	login := dockerRecording("", "", "login", "-u", "user", "--password-stdin", "registry.example.com")
+	inspect := func(stdout string, err string, builder string) Recording {
+		return dockerRecording(stdout, err, "buildx", "inspect", builder)
+	}
+	buildx := []string{"buildx", "build", "--builder", defaultBuildxBuilder, "--load"}
+	registryCache := []string{
+		"--cache-from", "type=registry,ref=" + repo + ":" + branch + "-buildcache",
+		"--cache-from", "type=registry,ref=" + repo + ":latest-buildcache",
+		"--cache-to", "type=local,dest=" + dir + ",mode=min,oci-mediatypes=true,image-manifest=true",
+	}
+
+	tests := []struct {
+		name       string
+		cache      BuildCache
+		recordings []Recording
+		err        string
+	}{
+		{"none", BuildCache{}, []Recording{
+			buildRecording("", []string{"build"}),
+		}, ""},
+		{"inline", BuildCache{Mode: "inline"}, []Recording{
+			login,
+			dockerRecording("", "", "pull", repo+":"+branch),
+			dockerRecording("", "manifest unknown", "pull", repo+":latest"),
+			buildRecording("", []string{"build"}, "--cache-from", repo+":"+branch, "--build-arg", "BUILDKIT_INLINE_CACHE=1"),
+		}, ""},
+		{"registry", BuildCache{Mode: "registry"}, []Recording{
+			login,
+			inspect("Name:   gocloud-cicd\nDriver: docker-container\n", "", defaultBuildxBuilder),
+			buildRecording("", buildx, registryCache...),
+		}, ""},
+		{"registry max creates builder", BuildCache{Mode: "registry", Max: true}, []Recording{
+			login,
+			inspect("", `ERROR: no builder "gocloud-cicd" found`, defaultBuildxBuilder),
+			dockerRecording("", "", "buildx", "create", "--name", defaultBuildxBuilder, "--driver", "docker-container"),
+			buildRecording("", buildx, append(registryCache[:4:4], "--cache-to", strings.Replace(registryCache[5], "mode=min", "mode=max", 1))...),
+		}, ""},
+		{"registry with docker driver", BuildCache{Mode: "registry", Builder: "default"}, []Recording{
+			login,
+			inspect("Name:   default\nDriver: docker\n", "", "default"),
+		}, "cannot export cache"},
+		{"registry with missing builder", BuildCache{Mode: "registry", Builder: "ci"}, []Recording{
+			login,
+			inspect("", `ERROR: no builder "ci" found`, "ci"),
+		}, `no builder "ci" found`},
+		{"unknown mode", BuildCache{Mode: "local"}, nil, "unknown build cache mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := newBuildWorkflow(t)
+			wf.App.Build.Cache = tt.cache
+			e := NewReplayExecutor(tt.recordings)
+			wf.SetExecutor(e)
+
+			image, err := wf.Build()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Build error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image != "app:abc123" {
+				t.Errorf("image = %v", image)
+			}
+			if remaining := e.Remaining(); len(remaining) > 0 {
+				t.Errorf("commands not run: %v", remaining)
+			}
+		})
+	}
+}
+
+func TestBuildCacheTag(t *testing.T) {
+	tests := []string{"main", "feature/x", strings.Repeat("a", 128), strings.Repeat("feature/", 20)}
+	for _, branch := range tests {
+		tag := buildCacheTag(SanitizeTag(branch))
+		if !tagRE.MatchString(tag) || !strings.HasSuffix(tag, buildCacheSuffix) {
+			t.Errorf("%v: invalid cache tag %q", branch, tag)
+		}
+	}
+	if tag := buildCacheTag(SanitizeTag("feature/x")); tag != SanitizeTag("feature/x")+"-buildcache" {
+		t.Errorf("cache tag %q does not extend the branch tag", tag)
+	}
+}
+
+func TestExportBuildCache(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	reg := newTestRegistry(t)
+
+	wf := New()
+	wf.Config.Provider.Registry.ID = "oci"
+	wf.Provider.Registry.OCI.Url = reg.Host() + "/team/app"
+	wf.Provider.Registry.OCI.Insecure = true
+	wf.App.Build.Cache.Mode = "registry"
+	opts := Options{Image: "app:abc123", Branch: "feature/x"}
+
+	activeRegistry, err := wf.GetActiveRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar := activeRegistry.(Registrator)
+	if err = ar.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing saved by a build
+	if err = wf.pushBuildCache(ar, opts); err == nil || !strings.Contains(err.Error(), "no build cache") {
+		t.Fatalf("exported a missing build cache: %v", err)
+	}
+
+	digest := writeTestLayout(t, buildCacheDir(opts.Image), "cache layer")
+	if err = wf.pushBuildCache(ar, opts); err != nil {
+		t.Fatal(err)
+	}
+	tag := buildCacheTag(SanitizeTag("feature/x"))
+	if got := reg.Manifest("team/app", tag); got == nil || digestOf(got) != digest {
+		t.Errorf("cache tag %v = %s, want manifest %v", tag, got, digest)
+	}
+
+	// without a branch the cache goes to latest
+	opts.Branch = ""
+	if err = wf.pushBuildCache(ar, opts); err != nil {
+		t.Fatal(err)
+	}
+	if reg.Manifest("team/app", "latest-buildcache") == nil {
+		t.Error("latest cache tag not exported")
+	}
+
+	if _, err = os.Stat(buildCacheDir(opts.Image)); err != nil {
+		t.Errorf("build cache removed by export: %v", err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	wf := newBuildWorkflow(t)
+	wf.App.Repo = "github.com/team/app"
+	wf.App.Build = AppBuild{
+		Dockerfile: "docker/../docker/Dockerfile",
+		Context:    "src",
+		Args:       map[string]string{"REVISION": "{{.Commit}}", "CHANNEL": "stable", "BRANCH": "{{.Branch}}"},
+	}
+	wf.Options.Version = "v1.2.0"
+	wf.Options.BuildArgs = []string{"CHANNEL=beta", "EXTRA=a=b"}
+	e := NewReplayExecutor([]Recording{dockerRecording("", "", "build", "-t", "app:abc123",
+		"-f", "docker/Dockerfile",
+		"--build-arg", "BRANCH=feature/x",
+		"--build-arg", "CHANNEL=beta",
+		"--build-arg", "EXTRA=a=b",
+		"--build-arg", "REVISION=abc123",
+		"--label", labelTitle+"=app",
+		"--label", labelSource+"=https://github.com/team/app",
+		"--label", labelRevision+"=abc123",
+		"--label", labelCreated+"=2024-06-30T12:00:00Z",
+		"--label", labelBranch+"=feature/x",
+		"--label", labelVersion+"=v1.2.0",
+		"src")})
+	wf.SetExecutor(e)
+
+	image, err := wf.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image != "app:abc123" || wf.Options.Image != "" {
+		t.Errorf("image = %v, options image = %v", image, wf.Options.Image)
+	}
+	if remaining := e.Remaining(); len(remaining) > 0 {
+		t.Errorf("commands not run: %v", remaining)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(wf *Workflow)
+		err   string
+	}{
+		{"no app name", func(wf *Workflow) { wf.App.Name = "" }, "build image name required"},
+		{"no commit", func(wf *Workflow) { wf.Options.Commit = "" }, "build commit unknown"},
+		{"untagged image", func(wf *Workflow) { wf.Options.Image = "app" }, "must be tagged"},
+		{"bad arg template", func(wf *Workflow) { wf.App.Build.Args = map[string]string{"V": "{{.Version"} }, "build arg V"},
+		{"unknown arg variable", func(wf *Workflow) { wf.App.Build.Args = map[string]string{"V": "{{.Nope}}"} }, "build arg V"},
+		{"bad arg option", func(wf *Workflow) { wf.Options.BuildArgs = []string{"=x"} }, "must be KEY=VALUE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := newBuildWorkflow(t)
+			tt.setup(wf)
+			wf.SetExecutor(NewReplayExecutor(nil))
+
+			if _, err := wf.Build(); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Build error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package cicd
+
+import (
+	"fmt"
+	"log"
+)
+
+// BuildInfo is the build context reported by a CI provider.  Fields the provider cannot determine are
+// left empty
+type BuildInfo struct {
+	Branch string
+	Event  string
+	PR     string
+	Commit string
+	Build  string
+
+	// Tag is the git tag of a tag build, used as the release version
+	Tag string
+
+	// Dirty is set when the working tree has uncommitted changes to tracked files
+	Dirty bool
+}
+
+// CIProvider detects the build context from the CI service environment so push and deploy need not be
+// given --branch, --event and --pr by hand
+type CIProvider interface {
+	BuildInfo() (BuildInfo, error)
+}
+
+// applyBuildInfo fills the run options not set by flags from the active CI provider, falling back to the
+// local git repository when no CI environment is detected.  The event defaults to push.  dirty reports
+// uncommitted changes in the working tree
+func (wf *Workflow) applyBuildInfo(opts *Options) (dirty bool, err error) {
+	defer func() {
+		if opts.Event == "" {
+			opts.Event = "push"
+		}
+	}()
+
+	var activeCI interface{}
+	if activeCI, err = wf.GetActiveCIProvider(); err != nil {
+		return dirty, err
+	}
+
+	var bi BuildInfo
+	if activeCI != nil {
+		if bi, err = activeCI.(CIProvider).BuildInfo(); err != nil {
+			return dirty, fmt.Errorf("CI provider %v: %v", wf.Config.Provider.CI.ID, err)
+		}
+	}
+	if bi == (BuildInfo{}) && activeCI != &wf.Provider.CI.LocalGit {
+		wf.Provider.CI.LocalGit.SetExecutor(wf.Executor())
+		if bi, err = wf.Provider.CI.LocalGit.BuildInfo(); err != nil {
+			return dirty, fmt.Errorf("local git: %v", err)
+		}
+	}
+	wf.LogDebug(fmt.Sprintf("CI build info: %+v", bi))
+
+	setDefault(&opts.Branch, bi.Branch)
+	setDefault(&opts.Event, bi.Event)
+	setDefault(&opts.PR, bi.PR)
+	setDefault(&opts.Commit, bi.Commit)
+	setDefault(&opts.Build, bi.Build)
+	setDefault(&opts.Version, bi.Tag)
+
+	if bi != (BuildInfo{}) {
+		log.Printf("CI build: branch=%v event=%v pr=%v commit=%v build=%v dirty=%v\n", opts.Branch, opts.Event, opts.PR, opts.Commit, opts.Build, bi.Dirty)
+	}
+	return bi.Dirty, err
+}
+
+func setDefault(option *string, value string) {
+	if *option == "" {
+		*option = value
+	}
+}
@@ -0,0 +1,99 @@
+package cicd
+
+import (
+	"testing"
+)
+
+// setTestEnv sets each of keys to its value in env, clearing those env leaves out
+func setTestEnv(t *testing.T, keys []string, env map[string]string) {
+	t.Helper()
+	for _, k := range keys {
+		t.Setenv(k, env[k])
+	}
+}
+
+func TestTravisBuildInfo(t *testing.T) {
+	keys := []string{"TRAVIS", "TRAVIS_BRANCH", "TRAVIS_COMMIT", "TRAVIS_BUILD_NUMBER", "TRAVIS_TAG",
+		"TRAVIS_EVENT_TYPE", "TRAVIS_PULL_REQUEST", "TRAVIS_PULL_REQUEST_BRANCH"}
+	build := map[string]string{"TRAVIS": "true", "TRAVIS_COMMIT": "abc123", "TRAVIS_BUILD_NUMBER": "42"}
+	with := func(env map[string]string) map[string]string {
+		for k, v := range build {
+			env[k] = v
+		}
+		return env
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want BuildInfo
+	}{
+		{"not travis", map[string]string{"TRAVIS_BRANCH": "master"}, BuildInfo{}},
+		{"push", with(map[string]string{"TRAVIS_BRANCH": "master", "TRAVIS_EVENT_TYPE": "push"}),
+			BuildInfo{Branch: "master", Event: "push", Commit: "abc123", Build: "42"}},
+		{"cron", with(map[string]string{"TRAVIS_BRANCH": "master", "TRAVIS_EVENT_TYPE": "cron"}),
+			BuildInfo{Branch: "master", Event: "push", Commit: "abc123", Build: "42"}},
+		{"pull request", with(map[string]string{"TRAVIS_BRANCH": "master", "TRAVIS_EVENT_TYPE": "pull_request",
+			"TRAVIS_PULL_REQUEST": "7", "TRAVIS_PULL_REQUEST_BRANCH": "feature/x"}),
+			BuildInfo{Branch: "feature/x", Event: "pull_request", PR: "7", Commit: "abc123", Build: "42"}},
+		{"tag", with(map[string]string{"TRAVIS_BRANCH": "v1.2.0", "TRAVIS_EVENT_TYPE": "push", "TRAVIS_TAG": "v1.2.0"}),
+			BuildInfo{Branch: "v1.2.0", Event: "tag", Commit: "abc123", Build: "42", Tag: "v1.2.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestEnv(t, keys, tt.env)
+			bi, err := (&Travis{}).BuildInfo()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bi != tt.want {
+				t.Errorf("BuildInfo = %+v, want %+v", bi, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyBuildInfo(t *testing.T) {
+	setTestEnv(t, []string{"TRAVIS", "TRAVIS_BRANCH", "TRAVIS_COMMIT", "TRAVIS_BUILD_NUMBER", "TRAVIS_TAG",
+		"TRAVIS_EVENT_TYPE", "TRAVIS_PULL_REQUEST", "TRAVIS_PULL_REQUEST_BRANCH"}, map[string]string{
+		"TRAVIS": "true", "TRAVIS_BRANCH": "master", "TRAVIS_EVENT_TYPE": "pull_request", "TRAVIS_PULL_REQUEST": "7",
+		"TRAVIS_PULL_REQUEST_BRANCH": "feature/x", "TRAVIS_COMMIT": "abc123", "TRAVIS_BUILD_NUMBER": "42",
+	})
+
+	tests := []struct {
+		name string
+		ci   string
+		opts Options
+		want Options
+	}{
+		{"detected", "travis", Options{},
+			Options{Branch: "feature/x", Event: "pull_request", PR: "7", Commit: "abc123", Build: "42"}},
+		{"flags win", "travis", Options{Branch: "hotfix", Event: "push", Commit: "def456"},
+			Options{Branch: "hotfix", Event: "push", PR: "7", Commit: "def456", Build: "42"}},
+		{"no provider defaults to push", "", Options{Branch: "master"},
+			Options{Branch: "master", Event: "push"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := New()
+			wf.Config.Provider.CI.ID = tt.ci
+			wf.Provider.CI.LocalGit.Dir = t.TempDir() // not a git repository
+			wf.SetExecutor(NewReplayExecutor(nil))
+
+			opts := tt.opts
+			if _, err := wf.applyBuildInfo(&opts); err != nil {
+				t.Fatal(err)
+			}
+			if opts.Branch != tt.want.Branch || opts.Event != tt.want.Event || opts.PR != tt.want.PR ||
+				opts.Commit != tt.want.Commit || opts.Build != tt.want.Build {
+				t.Errorf("options = %+v, want %+v", opts, tt.want)
+			}
+		})
+	}
+
+	wf := New()
+	wf.Config.Provider.CI.ID = "circleci"
+	if _, err := wf.applyBuildInfo(&Options{}); err == nil {
+		t.Error("unknown CI provider accepted")
+	}
+}
@@ -0,0 +1,473 @@
+package cicd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetentionRule is one entry of the tag retention policy in cicd.yaml.  Each tag is governed by the first
+// rule whose Pattern (a path.Match glob, e.g. PR-*) matches it; tags matching no rule are kept.  The Keep
+// newest matching tags are always kept.  The rest are deleted when older than Maxage (a duration such as
+// 336h or a number of days such as 14d), or regardless of age when Maxage is empty and Keep is set.
+// Signature (.sig) and build cache (-buildcache) tags are governed only by rules whose Pattern ends in
+// the same suffix, e.g. *-buildcache, so a catch-all * never removes them
+type RetentionRule struct {
+	Pattern string
+	Keep    int
+	Maxage  string
+}
+
+// TagReport is the retention decision for one tag
+type TagReport struct {
+	Tag     string
+	Digest  string
+	Created time.Time
+	Delete  bool
+	Reason  string
+
+	// children are the platform manifests of an image index
+	children []string
+}
+
+// reservedTagSuffixes mark tags stored alongside images rather than naming images of their own
+var reservedTagSuffixes = []string{".sig", "-buildcache"}
+
+// Cleanup applies the retention rules to the tags of the active registry, never deleting a tag whose
+// image is deployed to the active platform.  In dryrun mode only the report is produced
+func (wf *Workflow) Cleanup() (report []TagReport, err error) {
+	rules := wf.App.Retention
+	if len(rules) == 0 {
+		return report, fmt.Errorf("%v", "no tag retention rules in cicd.yaml")
+	}
+	if err = validateRetention(rules); err != nil {
+		return report, err
+	}
+
+	var activeRegistry interface{}
+	if activeRegistry, err = wf.GetActiveRegistry(); err != nil {
+		return report, err
+	}
+	ar := activeRegistry.(Registrator)
+	if err = ar.IsRegistryValid(); err != nil {
+		return report, err
+	}
+	if err = ar.Authenticate(); err != nil {
+		return report, err
+	}
+
+	repoURL := ar.GetRepoURL()
+	api, ok := ar.(RegistryAPI)
+	if !ok || api.Client() == nil {
+		return report, fmt.Errorf("%v: registry api unavailable for cleanup", repoURL)
+	}
+	c := api.Client()
+	_, repo := splitRepoURL(repoURL)
+
+	var tags []string
+	if tags, err = c.Tags(repo); err != nil {
+		return report, err
+	}
+
+	var deployed []string
+	if deployed, err = wf.deployedImages(); err != nil {
+		return report, fmt.Errorf("cannot determine deployed images: %v", err)
+	}
+
+	if report, err = tagDetails(c, repo, tags, wf.Config.Provider.Registry.Concurrency); err != nil {
+		return report, err
+	}
+	applyRetention(report, rules, protectedDigests(repoURL, report, deployed), time.Now())
+
+	for _, r := range report {
+		action := "keep"
+		if r.Delete {
+			action = "delete"
+		}
+		log.Printf("cleanup: %-6v %v:%v (created %v) %v\n", action, repoURL, r.Tag, formatCreated(r.Created), r.Reason)
+	}
+	if wf.IsDryRun() {
+		log.Println("dryrun: no tags deleted")
+		return report, err
+	}
+
+	return report, deleteTags(c, repo, report)
+}
+
+func validateRetention(rules []RetentionRule) (err error) {
+	for _, rule := range rules {
+		if _, err = path.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" {
+			return fmt.Errorf("retention pattern %q invalid", rule.Pattern)
+		}
+		if rule.Keep < 0 {
+			return fmt.Errorf("retention keep for %q must not be negative", rule.Pattern)
+		}
+		if _, err = parseAge(rule.Maxage); err != nil {
+			return fmt.Errorf("retention maxage for %q: %v", rule.Pattern, err)
+		}
+	}
+	return err
+}
+
+// parseAge parses a duration, also accepting a whole number of days such as 14d.  Empty is zero
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// tagDetails resolves each tag's manifest digest and image creation time
+func tagDetails(c *RegistryClient, repo string, tags []string, limit int) (report []TagReport, err error) {
+	if limit < 1 {
+		limit = 1
+	}
+	report = make([]TagReport, len(tags))
+	errs := make([]error, len(tags))
+
+	// tags sharing a manifest are resolved once
+	var mu sync.Mutex
+	created := map[string]time.Time{}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, limit)
+	for i, tag := range tags {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, tag string) {
+			defer func() { <-sem; wg.Done() }()
+
+			r := TagReport{Tag: tag}
+			mediaType, body, err := c.GetManifest(repo, tag)
+			if err != nil || body == nil {
+				report[i], errs[i] = r, err
+				return
+			}
+			r.Digest = digestOf(body)
+			r.children = indexChildren(mediaType, body)
+
+			// signatures and build caches are not images; they are dated by inheritCreated
+			if reservedSuffix(tag) != "" {
+				report[i] = r
+				return
+			}
+
+			mu.Lock()
+			t, ok := created[r.Digest]
+			mu.Unlock()
+			if !ok {
+				if t, err = imageCreated(c, repo, mediaType, body); err != nil {
+					log.Println("cleanup:", tag, err)
+				}
+				mu.Lock()
+				created[r.Digest] = t
+				mu.Unlock()
+			}
+			r.Created = t
+			report[i] = r
+		}(i, tag)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return report, fmt.Errorf("tag %v: %v", tags[i], err)
+		}
+	}
+	return report, err
+}
+
+// indexChildren returns the digests of the platform manifests of an image index, nil for other manifests
+func indexChildren(mediaType string, body []byte) (children []string) {
+	if mediaType != MediaTypeOCIIndex && mediaType != MediaTypeDockerManifestList {
+		return children
+	}
+	var index Index
+	if json.Unmarshal(body, &index) != nil {
+		return children
+	}
+	for _, m := range index.Manifests {
+		children = append(children, m.Digest)
+	}
+	return children
+}
+
+// imageCreated reads the creation time from the image config, using the first platform of an index
+func imageCreated(c *RegistryClient, repo string, mediaType string, body []byte) (created time.Time, err error) {
+	if mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerManifestList {
+		var index Index
+		if err = json.Unmarshal(body, &index); err != nil || len(index.Manifests) == 0 {
+			return created, fmt.Errorf("image index without manifests")
+		}
+		if mediaType, body, err = c.GetManifest(repo, index.Manifests[0].Digest); err != nil || body == nil {
+			return created, fmt.Errorf("image index manifest %v unavailable", index.Manifests[0].Digest)
+		}
+	}
+
+	var m Manifest
+	if err = json.Unmarshal(body, &m); err != nil {
+		return created, err
+	}
+	rc, err := c.GetBlob(repo, m.Config.Digest)
+	if err != nil {
+		return created, err
+	}
+	defer rc.Close()
+
+	var config struct {
+		Created time.Time
+	}
+	if err = json.NewDecoder(rc).Decode(&config); err != nil {
+		return created, fmt.Errorf("image config: %v", err)
+	}
+	return config.Created, err
+}
+
+// deployedImages lists the images of every pod on the active platform, as references and pulled digests
+func (wf *Workflow) deployedImages() (images []string, err error) {
+	ctx, err := wf.platformContext()
+	if err != nil {
+		return images, err
+	}
+
+	retry := wf.Config.Provider.Platform.Retry
+	if err = retry.Validate(); err != nil {
+		return images, fmt.Errorf("platform %v", err)
+	}
+
+	// read-only, so run in dryrun mode too
+	res, err := NewRetryExecutor(wf.Executor(), retry).Execute(Command{
+		Name:   "kubectl",
+		Args:   []string{"--context", ctx, "get", "pods", "--all-namespaces", "-o", "jsonpath={..image} {..imageID}"},
+		Always: true,
+		Quiet:  true,
+	})
+	if err != nil {
+		return images, err
+	}
+	return strings.Fields(string(res.Stdout)), err
+}
+
+// protectedDigests returns the digests of tags deployed by reference or by digest
+func protectedDigests(repoURL string, report []TagReport, deployed []string) map[string]bool {
+	repo, _ := ParseRepository(repoURL)
+
+	protected := map[string]bool{}
+	for _, image := range deployed {
+		// pulled image ids look like docker-pullable://repo@sha256:...
+		if i := strings.Index(image, "://"); i >= 0 {
+			image = image[i+3:]
+		}
+		ref, err := ParseReference(image)
+		if err != nil || ref.APIHost() != repo.APIHost() || ref.APIPath() != repo.APIPath() {
+			continue
+		}
+		if ref.Digest != "" {
+			protected[ref.Digest] = true
+		}
+		if ref.Tag != "" {
+			for _, r := range report {
+				if r.Tag == ref.Tag {
+					protected[r.Digest] = true
+				}
+			}
+		}
+	}
+	return protected
+}
+
+// reservedSuffix returns the suffix of a signature or build cache tag, "" for other tags
+func reservedSuffix(tag string) string {
+	for _, suffix := range reservedTagSuffixes {
+		if strings.HasSuffix(tag, suffix) {
+			return suffix
+		}
+	}
+	return ""
+}
+
+// inheritCreated dates signature and build cache tags, which record no creation time of their own, by the
+// image they sign and the branch tag they cache.  A signature of an image no longer tagged stays undated
+func inheritCreated(report []TagReport) {
+	byDigest := map[string]time.Time{}
+	byTag := map[string]time.Time{}
+	for _, r := range report {
+		if reservedSuffix(r.Tag) != "" || r.Created.IsZero() {
+			continue
+		}
+		byTag[r.Tag] = r.Created
+		if r.Created.After(byDigest[r.Digest]) {
+			byDigest[r.Digest] = r.Created
+		}
+	}
+
+	for i := range report {
+		r := &report[i]
+		if !r.Created.IsZero() {
+			continue
+		}
+		switch reservedSuffix(r.Tag) {
+		case ".sig":
+			r.Created = byDigest[strings.Replace(strings.TrimSuffix(r.Tag, ".sig"), "-", ":", 1)]
+		case "-buildcache":
+			r.Created = byTag[strings.TrimSuffix(r.Tag, "-buildcache")]
+		}
+	}
+}
+
+// applyRetention marks the tags to delete, newest first within each rule
+func applyRetention(report []TagReport, rules []RetentionRule, protected map[string]bool, now time.Time) {
+	inheritCreated(report)
+	sort.SliceStable(report, func(i, j int) bool {
+		return report[i].Created.After(report[j].Created)
+	})
+
+	kept := make([]int, len(rules))
+	for i := range report {
+		r := &report[i]
+
+		// signature and build cache tags are only matched by rules naming their suffix
+		reserved := reservedSuffix(r.Tag)
+
+		rule := -1
+		for j, rr := range rules {
+			if reserved != "" && !strings.HasSuffix(rr.Pattern, reserved) {
+				continue
+			}
+			if ok, _ := path.Match(rr.Pattern, r.Tag); ok {
+				rule = j
+				break
+			}
+		}
+
+		var maxAge time.Duration
+		if rule >= 0 {
+			maxAge, _ = parseAge(rules[rule].Maxage)
+		}
+
+		switch {
+		case rule < 0 && reserved != "":
+			r.Reason = "no retention rule for " + reserved + " tags"
+		case rule < 0:
+			r.Reason = "no retention rule"
+		case protected[r.Digest]:
+			r.Reason = "deployed"
+		case kept[rule] < rules[rule].Keep:
+			kept[rule]++
+			r.Reason = fmt.Sprintf("within newest %d of %v", rules[rule].Keep, rules[rule].Pattern)
+		case r.Created.IsZero():
+			r.Reason = "creation time unknown"
+		case maxAge > 0 && now.Sub(r.Created) > maxAge:
+			r.Delete = true
+			r.Reason = fmt.Sprintf("older than %v", rules[rule].Maxage)
+		case maxAge == 0 && rules[rule].Keep > 0:
+			r.Delete = true
+			r.Reason = fmt.Sprintf("beyond newest %d of %v", rules[rule].Keep, rules[rule].Pattern)
+		default:
+			r.Reason = "retained by " + rules[rule].Pattern
+		}
+	}
+}
+
+// deleteTags deletes the marked tags one by one, then deletes by digest each manifest left without tags,
+// together with its signature; registries such as GCR refuse to delete a manifest tags still point at.
+// Manifests that kept images depend on, the platform manifests of a kept index and the signatures of a
+// kept image, are never deleted by digest, and the signature tags of kept images are not deleted at all
+func deleteTags(c *RegistryClient, repo string, report []TagReport) (err error) {
+	remaining := map[string]int{}
+	referenced := map[string]bool{}
+	signatures := map[string]bool{}
+	byTag := map[string]TagReport{}
+	for _, r := range report {
+		byTag[r.Tag] = r
+		if r.Delete {
+			continue
+		}
+		remaining[r.Digest]++
+		for _, child := range r.children {
+			referenced[child] = true
+		}
+		if r.Digest != "" {
+			signatures[signatureTag(r.Digest)] = true
+		}
+	}
+	for _, r := range report {
+		if signatures[r.Tag] {
+			referenced[r.Digest] = true
+		}
+	}
+
+	var failed []string
+	deleted := map[string]bool{}
+	deleteRef := func(name string, ref string) bool {
+		if deleted[ref] {
+			return true
+		}
+		if derr := c.DeleteManifest(repo, ref); derr != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", name, derr))
+			return false
+		}
+		deleted[ref] = true
+		log.Println("cleanup: deleted", name)
+		return true
+	}
+
+	// untag first, noting the manifests left untagged and any whose tags could not all be removed
+	var untagged []string
+	incomplete := map[string]bool{}
+	for _, r := range report {
+		if !r.Delete {
+			continue
+		}
+		if signatures[r.Tag] {
+			log.Println("cleanup: kept", repo+":"+r.Tag, "signs a retained image")
+			continue
+		}
+		if !deleteRef(repo+":"+r.Tag, r.Tag) {
+			incomplete[r.Digest] = true
+		}
+		if r.Digest != "" && remaining[r.Digest] == 0 && !referenced[r.Digest] && !contains(untagged, r.Digest) {
+			untagged = append(untagged, r.Digest)
+		}
+	}
+
+	for _, digest := range untagged {
+		if incomplete[digest] || !deleteRef(repo+"@"+digest, digest) {
+			continue
+		}
+
+		// the signature of a deleted image goes with it
+		sig, ok := byTag[signatureTag(digest)]
+		if !ok || deleted[sig.Tag] {
+			continue
+		}
+		if deleteRef(repo+":"+sig.Tag, sig.Tag) && sig.Digest != "" {
+			deleteRef(repo+"@"+sig.Digest, sig.Digest)
+		}
+	}
+
+	if len(failed) > 0 {
+		err = fmt.Errorf("%d deletes failed:\n%v", len(failed), strings.Join(failed, "\n"))
+	}
+	return err
+}
+
+func formatCreated(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,191 @@
+package cicd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyRetention(t *testing.T) {
+	now := time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(n int) time.Time { return now.Add(-time.Duration(n) * 24 * time.Hour) }
+
+	rules := []RetentionRule{
+		{Pattern: "PR-*", Keep: 1, Maxage: "7d"},
+		{Pattern: "sha256-*.sig", Maxage: "30d"},
+		{Pattern: "*-buildcache", Maxage: "30d"},
+		{Pattern: "*", Keep: 2},
+	}
+	report := []TagReport{
+		{Tag: "PR-1", Digest: "sha256:pr1", Created: daysAgo(30)},
+		{Tag: "PR-2", Digest: "sha256:pr2", Created: daysAgo(20)},
+		{Tag: "PR-3", Digest: "sha256:pr3", Created: daysAgo(10)},
+		{Tag: "PR-4", Digest: "sha256:deployed", Created: daysAgo(40)},
+		{Tag: "PR-5", Digest: "sha256:pr5", Created: daysAgo(3)},
+		{Tag: "PR-6", Digest: "sha256:pr6"},
+		{Tag: "main", Digest: "sha256:main", Created: daysAgo(1)},
+		{Tag: "abc123", Digest: "sha256:main", Created: daysAgo(1)},
+		{Tag: "def456", Digest: "sha256:old", Created: daysAgo(50)},
+		// signatures and build caches have no creation time of their own
+		{Tag: "main-buildcache", Digest: "sha256:maincache"},
+		{Tag: "def456-buildcache", Digest: "sha256:oldcache"},
+		{Tag: "gone-buildcache", Digest: "sha256:gonecache"},
+		{Tag: "sha256-old.sig", Digest: "sha256:oldsig"},
+		{Tag: "sha256-main.sig", Digest: "sha256:mainsig"},
+	}
+	protected := map[string]bool{"sha256:deployed": true}
+	applyRetention(report, rules, protected, now)
+
+	want := map[string]string{
+		"PR-5":              "keep within newest 1 of PR-*",
+		"PR-3":              "delete older than 7d",
+		"PR-2":              "delete older than 7d",
+		"PR-1":              "delete older than 7d",
+		"PR-4":              "keep deployed",
+		"PR-6":              "keep creation time unknown",
+		"main":              "keep within newest 2 of *",
+		"abc123":            "keep within newest 2 of *",
+		"def456":            "delete beyond newest 2 of *",
+		"main-buildcache":   "keep retained by *-buildcache",
+		"def456-buildcache": "delete older than 30d",
+		"gone-buildcache":   "keep creation time unknown",
+		"sha256-old.sig":    "delete older than 30d",
+		"sha256-main.sig":   "keep retained by sha256-*.sig",
+	}
+	for _, r := range report {
+		got := "keep " + r.Reason
+		if r.Delete {
+			got = "delete " + r.Reason
+		}
+		if w := want[r.Tag]; got != w {
+			t.Errorf("%v: %q, want %q", r.Tag, got, w)
+		}
+	}
+
+	// newest first
+	for i := 1; i < len(report); i++ {
+		if report[i].Created.After(report[i-1].Created) {
+			t.Fatalf("report not sorted newest first: %v after %v", report[i].Tag, report[i-1].Tag)
+		}
+	}
+}
+
+func TestApplyRetentionReservedTags(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-1000 * time.Hour)
+
+	tests := []struct {
+		pattern string
+		deleted []string
+	}{
+		{"*", []string{"v1"}},
+		{"*-buildcache", []string{"main-buildcache"}},
+		{"*.sig", []string{"sha256-abc.sig"}},
+		{"main*", nil},
+	}
+	for _, tt := range tests {
+		report := []TagReport{
+			{Tag: "v1", Digest: "sha256:v1", Created: old},
+			{Tag: "main-buildcache", Digest: "sha256:cache", Created: old},
+			{Tag: "sha256-abc.sig", Digest: "sha256:sig", Created: old},
+		}
+		applyRetention(report, []RetentionRule{{Pattern: tt.pattern, Maxage: "1h"}}, nil, now)
+
+		var deleted []string
+		for _, r := range report {
+			if r.Delete {
+				deleted = append(deleted, r.Tag)
+			}
+		}
+		if strings.Join(deleted, ",") != strings.Join(tt.deleted, ",") {
+			t.Errorf("pattern %v deleted %v, want %v", tt.pattern, deleted, tt.deleted)
+		}
+	}
+}
+
+func TestDeleteTags(t *testing.T) {
+	reg := newTestRegistry(t)
+	const repo = "team/app"
+
+	shared := reg.PutImage(repo, "2024-01-01T00:00:00Z", "old", "current").Digest
+	unused := reg.PutImage(repo, "2024-01-02T00:00:00Z", "PR-1", "PR-1-alias").Digest
+	unusedSignature := reg.PutImage(repo, "2024-01-02T00:00:01Z", signatureTag(unused)).Digest
+	amd64 := reg.PutImage(repo, "2024-01-03T00:00:00Z", "v1-linux-amd64")
+	arm64 := reg.PutImage(repo, "2024-01-04T00:00:00Z")
+	reg.PutManifest(repo, MediaTypeOCIIndex, Index{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: []Descriptor{amd64, arm64}}, "v1")
+	signature := reg.PutImage(repo, "2024-01-05T00:00:00Z", signatureTag(shared)).Digest
+
+	c := reg.Client()
+	tags := []string{"old", "current", "PR-1", "PR-1-alias", signatureTag(unused), "v1-linux-amd64", "v1", signatureTag(shared)}
+	report, err := tagDetails(c, repo, tags, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range report {
+		switch report[i].Tag {
+		case "old", "PR-1", "PR-1-alias", "v1-linux-amd64", signatureTag(shared):
+			report[i].Delete = true
+		}
+	}
+
+	if err = deleteTags(c, repo, report); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref    string
+		exists bool
+	}{
+		{"old", false},
+		{"current", true},
+		{shared, true},
+		{"PR-1", false},
+		{"PR-1-alias", false},
+		{unused, false},
+		{signatureTag(unused), false},
+		{unusedSignature, false},
+		{"v1-linux-amd64", false},
+		{amd64.Digest, true},
+		{arm64.Digest, true},
+		{"v1", true},
+		{signatureTag(shared), true},
+		{signature, true},
+	}
+	for _, tt := range tests {
+		if exists := reg.Manifest(repo, tt.ref) != nil; exists != tt.exists {
+			t.Errorf("%v exists = %v, want %v", tt.ref, exists, tt.exists)
+		}
+	}
+}
+
+func TestDeleteTagsFailure(t *testing.T) {
+	reg := newTestRegistry(t)
+	const repo = "team/app"
+	digest := reg.PutImage(repo, "2024-01-01T00:00:00Z", "PR-1", "PR-2", "PR-3").Digest
+
+	c := reg.Client()
+	report, err := tagDetails(c, repo, []string{"PR-1", "PR-2", "PR-3"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range report {
+		report[i].Delete = true
+	}
+
+	// a tag that cannot be removed keeps the manifest; the other tags are still removed
+	reg.FailNext("/manifests/PR-2", 1)
+	err = deleteTags(c, repo, report)
+	if err == nil || !strings.Contains(err.Error(), "PR-2") || strings.Contains(err.Error(), "PR-1") {
+		t.Fatalf("deleteTags error = %v", err)
+	}
+	for ref, exists := range map[string]bool{"PR-1": false, "PR-2": true, "PR-3": false, digest: true} {
+		if got := reg.Manifest(repo, ref) != nil; got != exists {
+			t.Errorf("%v exists = %v, want %v", ref, got, exists)
+		}
+	}
+	for _, req := range reg.Requests() {
+		if req == "DELETE /v2/"+repo+"/manifests/"+digest {
+			t.Error("deleted a manifest by digest while a tag remained")
+		}
+	}
+}
@@ -1,14 +1,10 @@
 package cicd
 
 import (
-	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"os/exec"
-	"strings"
-
-	"github.com/spf13/viper"
+	"sync"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -17,6 +13,13 @@ type Workflow struct {
 	Config
 	App
 	Provider
+
+	Options Options `yaml:"-" mapstructure:"-"`
+
+	executor Executor
+
+	// loginMu serializes registry authentication, which may write the shared docker config
+	loginMu sync.Mutex
 }
 
 type Config struct {
@@ -31,14 +34,30 @@ type Config struct {
 		CD struct {
 			ID      string
 			Enabled bool
+			Retry   Retry
 		}
 		Registry struct {
 			ID      string
 			Enabled bool
+
+			// IDs pushes to several registries in one run; deploy uses the first
+			IDs []string
+
+			// OnError is the multi-registry failure policy: abort (default) stops at the first failed
+			// registry, continue pushes to the rest and reports every failure
+			OnError string
+
+			// Concurrency limits images pushed at once to each registry; RegistryConcurrency limits
+			// registries pushed to at once.  Both default to 1
+			Concurrency         int
+			RegistryConcurrency int
+
+			Retry Retry
 		}
 		Platform struct {
 			ID      string
 			Enabled bool
+			Retry   Retry
 		}
 	}
 }
@@ -46,6 +65,18 @@ type Config struct {
 type App struct {
 	Name string
 	Repo string
+
+	// Tags is the tag policy applied by push; empty uses the default scheme
+	Tags []TagRule
+
+	// Build declares the image build run by the build command
+	Build AppBuild
+
+	// Retention is the tag retention policy applied by the cleanup command
+	Retention []RetentionRule
+
+	// Signing configures signatures made by push and verified by deploy
+	Signing Signing
 }
 
 type Provider struct {
@@ -56,6 +87,10 @@ type Provider struct {
 
 	CI struct {
 		Travis
+		GitHub
+		GitLab
+		Jenkins
+		LocalGit
 	}
 
 	Platform struct {
@@ -70,18 +105,58 @@ type Provider struct {
 	Registry struct {
 		GCR
 		Docker
+		OCI
+		ECR
+		ACR
 	}
 }
 
 type Registrator interface {
 	IsRegistryValid() error
-	Push([]string) ([]string, error)
+	Push([]string) (PushResult, error)
 	Authenticate() error
 	GetRepoURL() string
 }
 
+// Deployer releases the service with the options of a single deploy run
 type Deployer interface {
-	Deploy(*Workflow) error
+	Deploy(*Workflow, Options) error
+}
+
+// SourcePusher is implemented by registries that push from a local OCI layout or tarball rather than
+// the docker daemon; docker tagging is skipped for them
+type SourcePusher interface {
+	SetSource(path string)
+}
+
+// TagLister is implemented by registries that can list the tags of their repository
+type TagLister interface {
+	ListTags() ([]string, error)
+}
+
+// RegistryAPI is implemented by registries exposing the distribution API client set up by Authenticate
+type RegistryAPI interface {
+	Client() *RegistryClient
+}
+
+// executorSetter is implemented by providers that run external commands
+type executorSetter interface {
+	SetExecutor(Executor)
+}
+
+// concurrencySetter is implemented by registries that push several images at once
+type concurrencySetter interface {
+	SetConcurrency(int)
+}
+
+// retrySetter is implemented by providers that retry operations outside an Executor (e.g. over HTTP)
+type retrySetter interface {
+	SetRetry(Retry)
+}
+
+// dryRunSetter is implemented by providers that act without an Executor (e.g. over HTTP)
+type dryRunSetter interface {
+	SetDryRun(bool)
 }
 
 func New() *Workflow {
@@ -89,6 +164,13 @@ func New() *Workflow {
 	return &wf
 }
 
+// Init seeds runtime options from the config file settings; call after Load or unmarshal and before
+// applying command line overrides
+func (wf *Workflow) Init() {
+	wf.Options.Debug = wf.Config.Debug
+	wf.Options.DryRun = wf.Config.Dryrun
+}
+
 func Load(cf string, wf *Workflow) error {
 	// read in config yaml file
 	yamlInput, err := ioutil.ReadFile(cf)
@@ -102,20 +184,121 @@ func Load(cf string, wf *Workflow) error {
 
 }
 
-//TODO: create getActive funcs for CIProvider and Platform
+// SetExecutor replaces the executor used by the workflow and its active providers
+func (wf *Workflow) SetExecutor(e Executor) {
+	wf.executor = e
+}
+
+// Executor returns the workflow executor, defaulting to the shell executor.  While Options.DryRun is set
+// it is wrapped in the dryrun executor, so changing the option takes effect on the next command
+func (wf *Workflow) Executor() Executor {
+	e := wf.executor
+	if e == nil {
+		e = NewShellExecutor()
+	}
+	if _, ok := e.(*DryRunExecutor); !ok && wf.IsDryRun() {
+		e = NewDryRunExecutor(e)
+	}
+	return e
+}
+
+// TODO: create getActive func for Platform
 func (wf *Workflow) GetActiveRegistry() (activeRegistry interface{}, err error) {
-	switch wf.Config.Provider.Registry.ID {
+	ids := wf.activeRegistryIDs()
+	if len(ids) == 0 {
+		ids = []string{""}
+	}
+	return wf.getRegistry(ids[0])
+}
+
+// GetActiveRegistries returns every registry indicated by config, in order
+func (wf *Workflow) GetActiveRegistries() (activeRegistries []interface{}, err error) {
+	ids := wf.activeRegistryIDs()
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no workflow registry configured")
+	}
+
+	for _, id := range ids {
+		var r interface{}
+		if r, err = wf.getRegistry(id); err != nil {
+			return nil, err
+		}
+		activeRegistries = append(activeRegistries, r)
+	}
+	return activeRegistries, err
+}
+
+func (wf *Workflow) activeRegistryIDs() []string {
+	if ids := wf.Config.Provider.Registry.IDs; len(ids) > 0 {
+		return ids
+	}
+	if id := wf.Config.Provider.Registry.ID; id != "" {
+		return []string{id}
+	}
+	return nil
+}
+
+func (wf *Workflow) getRegistry(id string) (activeRegistry interface{}, err error) {
+	switch id {
 	case "gcr":
 		activeRegistry = &wf.Provider.Registry.GCR
 	case "docker":
 		activeRegistry = &wf.Provider.Registry.Docker
+	case "oci":
+		activeRegistry = &wf.Provider.Registry.OCI
+	case "ecr":
+		activeRegistry = &wf.Provider.Registry.ECR
+	case "acr":
+		activeRegistry = &wf.Provider.Registry.ACR
 	default:
-		err = fmt.Errorf("unknown workflow registry: <%v>", wf.Config.Provider.Registry.ID)
+		err = fmt.Errorf("unknown workflow registry: <%v>", id)
 		log.Println(err)
 	}
+
+	retry := wf.Config.Provider.Registry.Retry
+	if verr := retry.Validate(); verr != nil && err == nil {
+		err = fmt.Errorf("registry %v", verr)
+	}
+	if es, ok := activeRegistry.(executorSetter); ok {
+		es.SetExecutor(NewRetryExecutor(wf.Executor(), retry))
+	}
+	if rs, ok := activeRegistry.(retrySetter); ok {
+		rs.SetRetry(retry)
+	}
+	if ds, ok := activeRegistry.(dryRunSetter); ok {
+		ds.SetDryRun(wf.IsDryRun())
+	}
+	if cs, ok := activeRegistry.(concurrencySetter); ok {
+		cs.SetConcurrency(wf.Config.Provider.Registry.Concurrency)
+	}
 	return activeRegistry, err
 }
 
+// GetActiveCIProvider returns the CI provider indicated by config, or nil when none is configured
+func (wf *Workflow) GetActiveCIProvider() (activeCI interface{}, err error) {
+	switch wf.Config.Provider.CI.ID {
+	case "":
+	case "travis":
+		activeCI = &wf.Provider.CI.Travis
+	case "github":
+		activeCI = &wf.Provider.CI.GitHub
+	case "gitlab":
+		activeCI = &wf.Provider.CI.GitLab
+	case "jenkins":
+		activeCI = &wf.Provider.CI.Jenkins
+	case "git":
+		activeCI = &wf.Provider.CI.LocalGit
+	default:
+		err = fmt.Errorf("unknown workflow CI provider: <%v>", wf.Config.Provider.CI.ID)
+		log.Println(err)
+	}
+
+	if es, ok := activeCI.(executorSetter); ok {
+		es.SetExecutor(wf.Executor())
+	}
+	return activeCI, err
+}
+
 func (wf *Workflow) GetActiveCDProvider() (activeCD interface{}, err error) {
 	switch wf.Config.Provider.CD.ID {
 	case "helm":
@@ -124,43 +307,42 @@ func (wf *Workflow) GetActiveCDProvider() (activeCD interface{}, err error) {
 		err = fmt.Errorf("unknown workflow CD provider: <%v>", wf.Config.Provider.CD.ID)
 		log.Println(err)
 	}
+
+	retry := wf.Config.Provider.CD.Retry
+	if verr := retry.Validate(); verr != nil && err == nil {
+		err = fmt.Errorf("CD provider %v", verr)
+	}
+	if es, ok := activeCD.(executorSetter); ok {
+		es.SetExecutor(NewRetryExecutor(wf.Executor(), retry))
+	}
 	return activeCD, err
 }
 
 func (wf *Workflow) UseContext() (err error) {
-	var stderr bytes.Buffer
-	var cmdOut []byte
-	var ctx string
-
-	switch wf.Config.Provider.Platform.ID {
-	case "gke":
-		ctx = wf.Provider.Platform.GKE.Context
-	case "minikube":
-		ctx = wf.Provider.Platform.MiniKube.Context
-	default:
-		LogError(fmt.Errorf("unknown platform provider: <%v>", wf.Config.Provider.Platform.ID))
+	ctx, cerr := wf.platformContext()
+	if cerr != nil {
+		LogError(cerr)
 	}
 
-	cmd := exec.Command("kubectl", "config", "use-context", ctx)
-	cmd.Stderr = &stderr
-
-	log.Println(viper.GetString("cmdMode"), strings.Join(cmd.Args, " "))
-
-	if !IsDryRun() {
-		cmd.Stderr = &stderr
-		if cmdOut, err = cmd.Output(); err != nil {
-			err = fmt.Errorf("%v", stderr.String())
-			return err
-		}
-		logCmdOutput(cmdOut)
+	retry := wf.Config.Provider.Platform.Retry
+	if err = retry.Validate(); err != nil {
+		return fmt.Errorf("platform %v", err)
 	}
 
+	_, err = NewRetryExecutor(wf.Executor(), retry).Execute(Command{Name: "kubectl", Args: []string{"config", "use-context", ctx}})
 	return err
 
 }
 
-func logCmdOutput(cmdOut []byte) {
-	for _, o := range strings.Split(strings.TrimSpace(string(cmdOut)), "\n") {
-		log.Println(o)
+// platformContext returns the kubectl context of the active platform
+func (wf *Workflow) platformContext() (ctx string, err error) {
+	switch wf.Config.Provider.Platform.ID {
+	case "gke":
+		ctx = wf.Provider.Platform.GKE.Context
+	case "minikube":
+		ctx = wf.Provider.Platform.MiniKube.Context
+	default:
+		err = fmt.Errorf("unknown platform provider: <%v>", wf.Config.Provider.Platform.ID)
 	}
+	return ctx, err
 }
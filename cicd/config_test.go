@@ -0,0 +1,39 @@
+package cicd
+
+import (
+	"testing"
+)
+
+func TestGetRegistryAppliesRetry(t *testing.T) {
+	wf := New()
+	wf.Config.Provider.Registry.Retry = Retry{Attempts: 3, Backoff: "10ms"}
+
+	for _, id := range []string{"gcr", "docker", "oci", "ecr", "acr"} {
+		r, err := wf.getRegistry(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := r.(retrySetter); !ok {
+			t.Errorf("registry %v does not accept the retry policy", id)
+		}
+	}
+
+	// the registry api client set up by Authenticate carries the policy
+	t.Setenv("DOCKER_USER", "user")
+	t.Setenv("DOCKER_PASSWORD", "s3cret")
+	wf.Provider.Registry.Docker.Url = "registry.example.com/team/app"
+	wf.SetExecutor(NewReplayExecutor([]Recording{
+		{Command: Command{Name: "docker", Args: []string{"login", "-u", "user", "--password-stdin", "registry.example.com"}}},
+	}))
+
+	r, err := wf.getRegistry("docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.(Registrator).Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.(RegistryAPI).Client().Retry; got.Attempts != 3 || got.Backoff != "10ms" {
+		t.Errorf("docker registry client retry = %+v", got)
+	}
+}
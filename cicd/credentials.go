@@ -0,0 +1,196 @@
+package cicd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Credentials declares where a registry's credentials come from.  Source is one of:
+//
+//	env           username and password from Userenv/Passwordenv (provider defaults when empty)
+//	dockerconfig  auths, credHelpers or credsStore of a docker config.json (Config, else the docker default)
+//	helper        the docker-credential-<Helper> program
+//
+// The docker cli is logged in with the resolved credentials unless they come from its own config.json
+type Credentials struct {
+	Source      string
+	Userenv     string
+	Passwordenv string
+	Config      string
+	Helper      string
+}
+
+// Resolve returns the username and secret for the registry host.  loggedIn reports credentials the docker
+// cli already holds (from the config.json it reads), so no further docker login is needed
+func (c Credentials) Resolve(e Executor, host string, userEnv string, passwordEnv string) (user string, pass string, loggedIn bool, err error) {
+	switch c.Source {
+	case "", "env":
+		if c.Userenv != "" {
+			userEnv = c.Userenv
+		}
+		if c.Passwordenv != "" {
+			passwordEnv = c.Passwordenv
+		}
+		if user = os.Getenv(userEnv); user == "" {
+			return user, pass, loggedIn, fmt.Errorf("%v environment variable not set", userEnv)
+		}
+		if pass = os.Getenv(passwordEnv); pass == "" {
+			return user, pass, loggedIn, fmt.Errorf("%v environment variable not set", passwordEnv)
+		}
+
+	case "dockerconfig":
+		user, pass, err = dockerConfigCredentials(e, c.Config, host)
+		loggedIn = err == nil && isDockerCLIConfig(c.Config)
+
+	case "helper":
+		if c.Helper == "" {
+			return user, pass, loggedIn, fmt.Errorf("credentials source helper requires a helper name")
+		}
+		user, pass, err = helperCredentials(e, c.Helper, host)
+
+	default:
+		err = fmt.Errorf("unknown credentials source: <%v>", c.Source)
+	}
+	return user, pass, loggedIn, err
+}
+
+// Login resolves the credentials for host and logs the docker cli in with them, unless it already holds them
+func (c Credentials) Login(e Executor, host string, userEnv string, passwordEnv string) (user string, pass string, err error) {
+	var loggedIn bool
+	if user, pass, loggedIn, err = c.Resolve(e, host, userEnv, passwordEnv); err != nil || loggedIn {
+		return user, pass, err
+	}
+	return user, pass, dockerLogin(e, host, user, pass)
+}
+
+// dockerConfig is the subset of ~/.docker/config.json used for registry credentials
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+func dockerConfigPath(path string) string {
+	if path != "" {
+		return path
+	}
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// isDockerCLIConfig reports whether path is the config.json the docker cli reads
+func isDockerCLIConfig(path string) bool {
+	if path == "" {
+		return true
+	}
+	configured, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	cli, err := filepath.Abs(dockerConfigPath(""))
+	return err == nil && configured == cli
+}
+
+func dockerConfigCredentials(e Executor, path string, host string) (user string, pass string, err error) {
+	path = dockerConfigPath(path)
+
+	var cfg dockerConfig
+	if err = readJSON(path, &cfg); err != nil {
+		return user, pass, fmt.Errorf("docker config: %v", err)
+	}
+
+	keys := dockerConfigKeys(host)
+
+	// per-registry helpers take precedence over the default store and inline auths
+	for _, k := range keys {
+		if helper, ok := cfg.CredHelpers[k]; ok {
+			return helperCredentials(e, helper, k)
+		}
+	}
+
+	for _, k := range keys {
+		a, ok := cfg.Auths[k]
+		if !ok {
+			continue
+		}
+		if a.IdentityToken != "" {
+			return acrTokenUser, a.IdentityToken, err
+		}
+		if a.Auth != "" {
+			return decodeDockerAuth(a.Auth)
+		}
+	}
+
+	if cfg.CredsStore != "" {
+		return helperCredentials(e, cfg.CredsStore, keys[0])
+	}
+	return user, pass, fmt.Errorf("no credentials for %v in %v", host, path)
+}
+
+// dockerConfigKeys lists the config.json keys under which host's credentials may be stored
+func dockerConfigKeys(host string) []string {
+	if host == dockerHubHost {
+		return []string{"https://index.docker.io/v1/", "index.docker.io", "docker.io", host}
+	}
+	return []string{host, "https://" + host, "http://" + host}
+}
+
+func decodeDockerAuth(auth string) (user string, pass string, err error) {
+	b, err := base64.StdEncoding.DecodeString(auth)
+	if err != nil {
+		return user, pass, fmt.Errorf("docker config auth: %v", err)
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 {
+		return user, pass, fmt.Errorf("docker config auth: expected user:password")
+	}
+	return parts[0], parts[1], err
+}
+
+// helperCredentials asks docker-credential-<helper> for the credentials of serverURL
+func helperCredentials(e Executor, helper string, serverURL string) (user string, pass string, err error) {
+	res, err := run(e, Command{
+		Name:  "docker-credential-" + helper,
+		Args:  []string{"get"},
+		Stdin: []byte(serverURL),
+		Quiet: true,
+	})
+	if err != nil {
+		return user, pass, fmt.Errorf("credential helper %v: %v", helper, err)
+	}
+
+	// dryrun executes nothing, leaving no output to decode
+	if len(res.Stdout) == 0 {
+		return user, pass, err
+	}
+
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err = json.Unmarshal(res.Stdout, &creds); err != nil {
+		return user, pass, fmt.Errorf("credential helper %v: %v", helper, err)
+	}
+	return creds.Username, creds.Secret, err
+}
+
+// dockerLogin logs the docker cli in to host, passing the password on stdin rather than argv and masking
+// it should docker echo it back
+func dockerLogin(e Executor, host string, user string, pass string) (err error) {
+	args := []string{"login", "-u", user, "--password-stdin"}
+	if host != dockerHubHost {
+		args = append(args, host)
+	}
+	_, err = run(e, Command{Name: "docker", Args: args, Stdin: []byte(pass), Secrets: []string{pass}})
+	return err
+}
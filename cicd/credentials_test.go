@@ -0,0 +1,131 @@
+package cicd
+
+import (
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeDockerConfig(t *testing.T, dir string, host string) string {
+	t.Helper()
+	path := filepath.Join(dir, "config.json")
+	auth := base64.StdEncoding.EncodeToString([]byte("user:s3cret"))
+	writeTestFile(t, path, `{"auths":{"`+host+`":{"auth":"`+auth+`"}}}`)
+	return path
+}
+
+func TestCredentialsLoginDockerConfig(t *testing.T) {
+	const host = "registry.example.com"
+	login := Recording{Command: Command{Name: "docker", Args: []string{"login", "-u", "user", "--password-stdin", host}}}
+
+	cliDir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", cliDir)
+	cliConfig := writeDockerConfig(t, cliDir, host)
+	otherConfig := writeDockerConfig(t, t.TempDir(), host)
+
+	tests := []struct {
+		name   string
+		config string
+		login  bool
+	}{
+		{"docker default", "", false},
+		{"docker cli config", cliConfig, false},
+		{"other config", otherConfig, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var recordings []Recording
+			if tt.login {
+				recordings = append(recordings, login)
+			}
+			e := NewReplayExecutor(recordings)
+
+			c := Credentials{Source: "dockerconfig", Config: tt.config}
+			user, pass, err := c.Login(e, host, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user != "user" || pass != "s3cret" {
+				t.Errorf("credentials = %v, %v", user, pass)
+			}
+			if remaining := e.Remaining(); len(remaining) > 0 {
+				t.Errorf("docker login not run: %v", remaining)
+			}
+		})
+	}
+}
+
+func TestDockerLoginMasksPassword(t *testing.T) {
+	const host = "registry.example.com"
+	rec := NewRecordingExecutor(NewReplayExecutor([]Recording{{
+		Command: Command{Name: "docker", Args: []string{"login", "-u", "user", "--password-stdin", host}},
+		Stderr:  "invalid password s3cret",
+		Error:   "login failed: invalid password s3cret",
+	}}))
+
+	if err := dockerLogin(rec, host, "user", "s3cret"); err == nil {
+		t.Fatal("failed login succeeded")
+	}
+	if r := rec.Recordings[0]; strings.Contains(r.Stderr+r.Error, "s3cret") || !strings.Contains(r.Error, "********") {
+		t.Errorf("recording holds the password: %+v", r)
+	}
+}
+
+func TestCredentialsLoginHelper(t *testing.T) {
+	const host = "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+	e := NewReplayExecutor([]Recording{
+		{Command: Command{Name: "docker-credential-ecr-login", Args: []string{"get"}}, Stdout: `{"Username":"AWS","Secret":"token"}`},
+		{Command: Command{Name: "docker", Args: []string{"login", "-u", "AWS", "--password-stdin", host}}},
+	})
+
+	user, pass, err := Credentials{Source: "helper", Helper: "ecr-login"}.Login(e, host, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "AWS" || pass != "token" || len(e.Remaining()) > 0 {
+		t.Errorf("credentials = %v, %v; unused recordings %v", user, pass, e.Remaining())
+	}
+}
+
+func TestECRCredentialsSource(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	const host = "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+
+	r := &ECR{Account: "123456789012", Region: "us-east-1", Repo: "team/app", Credentials: Credentials{Source: "helper", Helper: "ecr-login"}}
+	e := NewReplayExecutor([]Recording{
+		{Command: Command{Name: "docker-credential-ecr-login", Args: []string{"get"}}, Stdout: `{"Username":"AWS","Secret":"token"}`},
+		{Command: Command{Name: "docker", Args: []string{"login", "-u", "AWS", "--password-stdin", host}}},
+		{Command: Command{Name: "aws", Args: []string{"ecr", "describe-repositories", "--region", "us-east-1", "--registry-id", "123456789012", "--repository-names", "team/app"}}},
+	})
+	r.SetExecutor(e)
+
+	if err := r.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+	if c := r.Client(); c.Username != "AWS" || c.Password != "token" {
+		t.Errorf("client credentials = %v, %v", c.Username, c.Password)
+	}
+	if remaining := e.Remaining(); len(remaining) > 0 {
+		t.Errorf("unused recordings: %v", remaining)
+	}
+}
+
+func TestGCRCredentialsSource(t *testing.T) {
+	t.Setenv("GCR_USER", "_json_key")
+	t.Setenv("GCR_PASSWORD", "gcr-s3cret")
+
+	r := &GCR{Url: "gcr.io/project/app", Credentials: Credentials{Source: "env"}}
+	r.SetExecutor(NewReplayExecutor([]Recording{
+		{Command: Command{Name: "docker", Args: []string{"login", "-u", "_json_key", "--password-stdin", "gcr.io"}}},
+		{Command: Command{Name: "docker", Args: []string{"image", "inspect", "--format", "{{.Id}}", "gcr.io/project/app:abc"}}, Error: "no such image"},
+		{Command: Command{Name: "docker", Args: []string{"push", "gcr.io/project/app:abc"}}},
+	}))
+
+	if err := r.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Push([]string{"gcr.io/project/app:abc"}); err != nil {
+		t.Fatal(err)
+	}
+}
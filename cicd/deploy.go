@@ -0,0 +1,186 @@
+package cicd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+var digestRE = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// Deploy releases the service to the active platform using the active CD provider.  Defaults and
+// resolved digests apply to this run only; Options is left as the caller set it
+func (wf *Workflow) Deploy() (err error) {
+	opts := wf.Options
+
+	// initialize active Registry indicated by config and assert as Registrator
+	var activeRegistry interface{}
+	if activeRegistry, err = wf.GetActiveRegistry(); err != nil {
+		return err
+	}
+	ar := activeRegistry.(Registrator)
+
+	// detect build context from CI, then validate options and apply defaults
+	if _, err = wf.applyBuildInfo(&opts); err != nil {
+		return err
+	}
+	if err = wf.validateDeployOptions(ar, &opts); err != nil {
+		return err
+	}
+
+	// refuse images whose signature does not verify before rendering helm values
+	if err = wf.verifyDeployImage(ar, &opts); err != nil {
+		return err
+	}
+
+	//get active CD provider indicated by config and assert as Deployer
+	var activeCDProvider interface{}
+	if activeCDProvider, err = wf.GetActiveCDProvider(); err != nil {
+		return err
+	}
+	ad := activeCDProvider.(Deployer)
+
+	// use k8s context associated with platform
+	if err = wf.UseContext(); err != nil {
+		return err
+	}
+
+	// deploy using active CD provider
+	err = ad.Deploy(wf, opts)
+	return err
+}
+
+// TODO: options are helm specific.  add as method for CD provider.
+func (wf *Workflow) validateDeployOptions(ar Registrator, opts *Options) (err error) {
+
+	if opts.Tag == "" {
+		return fmt.Errorf("%v", "build tag a required value")
+	}
+
+	// accept raw branch names as tags, matching the sanitized tags written by push
+	opts.Tag = SanitizeTag(opts.Tag)
+	if !tagRE.MatchString(opts.Tag) {
+		return fmt.Errorf("build tag invalid: %q", opts.Tag)
+	}
+
+	if opts.Branch == "" {
+		return fmt.Errorf("%v", "branch a required value")
+	}
+
+	if opts.Namespace == "" {
+		if ns := wf.Provider.CD.Helm.Namespace; ns == "" {
+			return fmt.Errorf("%v", "namespace required when not defined in cicd.yaml")
+		} else {
+			opts.Namespace = ns
+		}
+	}
+
+	if opts.Chart == "" {
+		if cp := wf.Provider.CD.Helm.Chartpath; cp == "" {
+			return fmt.Errorf("%v", "chart path required when not defined in cicd.yaml")
+		} else {
+			opts.Chart = cp
+		}
+	}
+
+	// test existence of chart path
+	_, err = os.Stat(opts.Chart)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("chart path invalid: %v", opts.Chart)
+	}
+
+	if opts.Repo == "" {
+		if cr := ar.GetRepoURL(); cr == "" {
+			return fmt.Errorf("%v", "repoitory url required when not defined in cicd.yaml")
+		} else {
+			opts.Repo = cr
+		}
+	}
+
+	// normalize repository url; tags and digests are given separately
+	var repo Reference
+	if repo, err = ParseRepository(opts.Repo); err != nil {
+		return err
+	}
+	opts.Repo = repo.Repository()
+
+	// pin image by digest when given directly or recorded by a previous push
+	if opts.Digest == "" && opts.PushResult != "" {
+		var pr PushResults
+		if pr, err = ReadPushResults(opts.PushResult); err != nil {
+			return err
+		}
+		ref := opts.Repo + ":" + opts.Tag
+		if pi, ok := pr.Lookup(ref); !ok || pi.Digest == "" {
+			return fmt.Errorf("no digest for %v in push result %v", ref, opts.PushResult)
+		} else {
+			opts.Digest = pi.Digest
+		}
+	}
+
+	if opts.Digest != "" && !digestRE.MatchString(opts.Digest) {
+		return fmt.Errorf("image digest invalid: %v", opts.Digest)
+	}
+
+	if opts.Service == "" {
+		if svc := wf.App.Name; svc == "" {
+			return fmt.Errorf("%v", "service name required when not defined in cicd.yaml")
+		} else {
+			opts.Service = svc
+		}
+	}
+
+	if opts.Template == "" {
+		if tpl := wf.Provider.CD.Helm.Values.Template; tpl == "" {
+			return fmt.Errorf("%v", "helm values template required when not defined in cicd.yaml")
+		} else {
+			opts.Template = tpl
+		}
+	}
+
+	// test existence of helm values template
+	_, err = os.Stat(opts.Template)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("helm values template path invalid: %v", opts.Template)
+	}
+
+	return err
+}
+
+// verifyDeployImage checks the image signature when public keys are configured, pinning the deployment to
+// the verified digest
+func (wf *Workflow) verifyDeployImage(ar Registrator, opts *Options) (err error) {
+	keys := wf.App.Signing.Keys
+	if len(keys) == 0 {
+		return err
+	}
+
+	wf.loginMu.Lock()
+	err = ar.Authenticate()
+	wf.loginMu.Unlock()
+	if err != nil {
+		return err
+	}
+	api, ok := ar.(RegistryAPI)
+	if !ok || api.Client() == nil {
+		return fmt.Errorf("%v: registry api unavailable to verify signatures", ar.GetRepoURL())
+	}
+	c := api.Client()
+
+	// the registry client only reaches the active registry; an image elsewhere cannot be verified through it
+	host, repo := splitRepoURL(opts.Repo)
+	if registry, _ := splitRepoURL(ar.GetRepoURL()); host != registry {
+		return fmt.Errorf("deploy repository %v is not in the active registry %v; cannot verify its signature", opts.Repo, ar.GetRepoURL())
+	}
+
+	// resolve the tag so the verified digest is the one deployed
+	if opts.Digest == "" {
+		if opts.Digest, err = c.ManifestDigest(repo, opts.Tag); err != nil {
+			return err
+		}
+		if opts.Digest == "" {
+			return fmt.Errorf("image %v:%v not found", opts.Repo, opts.Tag)
+		}
+	}
+	return verifyImage(c, opts.Repo, opts.Digest, keys)
+}
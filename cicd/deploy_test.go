@@ -0,0 +1,134 @@
+package cicd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testDigest1 = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	testDigest2 = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+// newDeployWorkflow returns a workflow deploying app with helm to minikube from a docker registry, with
+// its chart, values template and values output in a temp dir
+func newDeployWorkflow(t *testing.T, template string) (wf *Workflow, values string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	wf = New()
+	wf.App.Name = "app"
+	wf.Provider.CI.LocalGit.Dir = dir // not a git repository
+	wf.Config.Provider.Registry.ID = "docker"
+	wf.Provider.Registry.Docker.Url = "registry.example.com/team/app"
+	wf.Config.Provider.CD.ID = "helm"
+	wf.Config.Provider.Platform.ID = "minikube"
+	wf.Provider.Platform.MiniKube.Context = "minikube"
+
+	helm := &wf.Provider.CD.Helm
+	helm.Namespace = "staging"
+	helm.Chartpath = filepath.Join(dir, "chart")
+	helm.Values.Template = filepath.Join(dir, "values.tpl")
+	helm.Values.Output = filepath.Join(dir, "values.yaml")
+	writeTestFile(t, filepath.Join(helm.Chartpath, "Chart.yaml"), "name: app\n")
+	writeTestFile(t, helm.Values.Template, template)
+	return wf, helm.Values.Output
+}
+
+// deployRecordings are the commands of a deploy of app from branch
+func deployRecordings(wf *Workflow, branch string) []Recording {
+	helm := wf.Provider.CD.Helm
+	return []Recording{
+		{Command: Command{Name: "kubectl", Args: []string{"config", "use-context", "minikube"}}},
+		{Command: Command{Name: "helm", Args: []string{"upgrade", "--install", ReleaseName("app", branch), "--namespace", helm.Namespace,
+			"--values", helm.Values.Output, helm.Chartpath}, Always: true}},
+	}
+}
+
+func TestDeployLeavesOptionsUnchanged(t *testing.T) {
+	wf, values := newDeployWorkflow(t, "image: {{.Image}}\n")
+
+	pushResult := filepath.Join(t.TempDir(), "push.json")
+	err := WritePushResults(pushResult, PushResults{{Registry: "docker", PushResult: PushResult{Pushed: []PushedImage{
+		{Ref: "registry.example.com/team/app:v1", Digest: testDigest1},
+		{Ref: "registry.example.com/team/app:v2", Digest: testDigest2},
+	}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		tag, want string
+	}{
+		{"v1", "image: registry.example.com/team/app@" + testDigest1},
+		{"v2", "image: registry.example.com/team/app@" + testDigest2},
+	}
+	for _, tt := range tests {
+		e := NewReplayExecutor(deployRecordings(wf, "main"))
+		wf.SetExecutor(e)
+		wf.Options = Options{Branch: "main", Tag: tt.tag, PushResult: pushResult}
+		before := wf.Options
+
+		if err = wf.Deploy(); err != nil {
+			t.Fatalf("%v: %v", tt.tag, err)
+		}
+		if got, _ := ioutil.ReadFile(values); strings.TrimSpace(string(got)) != tt.want {
+			t.Errorf("%v: values = %q, want %q", tt.tag, got, tt.want)
+		}
+		if !reflect.DeepEqual(wf.Options, before) {
+			t.Errorf("%v: options changed to %+v", tt.tag, wf.Options)
+		}
+		if remaining := e.Remaining(); len(remaining) > 0 {
+			t.Errorf("%v: commands not run: %v", tt.tag, remaining)
+		}
+	}
+}
+
+func TestDeployRequiresPinnedTemplate(t *testing.T) {
+	tests := []struct {
+		name, template, digest string
+		ok                     bool
+	}{
+		{"tag template without digest", "image: {{.Repo}}:{{.Tag}}\n", "", true},
+		{"tag template with digest", "image: {{.Repo}}:{{.Tag}}\n", testDigest1, false},
+		{"image template with digest", "image: {{.Image}}\n", testDigest1, true},
+		{"digest template with digest", "image: {{.Repo}}@{{.Digest}}\n", testDigest1, true},
+		{"tag template behind a digest conditional", "{{if .Digest}}# pinned{{end}}\nimage: {{.Repo}}:{{.Tag}}\n", testDigest1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf, _ := newDeployWorkflow(t, tt.template)
+			wf.SetExecutor(NewReplayExecutor(deployRecordings(wf, "main")))
+			wf.Options = Options{Branch: "main", Tag: "v1", Digest: tt.digest}
+
+			err := wf.Deploy()
+			if tt.ok && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.ok && (err == nil || !strings.Contains(err.Error(), "unpinned")) {
+				t.Fatalf("deployed the tag of a pinned image: %v", err)
+			}
+		})
+	}
+}
+
+func TestExecutorFollowsDryRun(t *testing.T) {
+	wf := New()
+	e := NewReplayExecutor(nil)
+	wf.SetExecutor(e)
+
+	if wf.Executor() != e {
+		t.Error("executor wrapped without dryrun")
+	}
+	wf.Options.DryRun = true
+	if _, ok := wf.Executor().(*DryRunExecutor); !ok {
+		t.Error("dryrun executor not used after setting DryRun")
+	}
+	wf.Options.DryRun = false
+	if wf.Executor() != e {
+		t.Error("dryrun executor still used after clearing DryRun")
+	}
+}
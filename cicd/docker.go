@@ -1,14 +1,7 @@
 package cicd
 
 import (
-	"bytes"
 	"fmt"
-	"log"
-	"os"
-	"os/exec"
-	"strings"
-
-	"github.com/spf13/viper"
 )
 
 type Docker struct {
@@ -18,34 +11,23 @@ type Docker struct {
 	Account     string
 	Repo        string
 	Url         string
+	Credentials Credentials
+
+	registryRuntime
 }
 
+// Authenticate logs in with credentials from the configured source, by default the DOCKER_USER and
+// DOCKER_PASSWORD environment variables
 func (r *Docker) Authenticate() (err error) {
-	var stderr bytes.Buffer
-	var cmdOut []byte
-
-	dockerUser := os.Getenv("DOCKER_USER")
-	if dockerUser == "" {
-		err = fmt.Errorf("DOCKER_USER environment variable not set")
-		return err
-	}
+	host, _ := splitRepoURL(r.Url)
 
-	dockerPass := os.Getenv("DOCKER_PASSWORD")
-	if dockerPass == "" {
-		err = fmt.Errorf("DOCKER_PASSWORD environment variable not set")
+	var user, pass string
+	if user, pass, err = r.Credentials.Login(r.executor, host, "DOCKER_USER", "DOCKER_PASSWORD"); err != nil {
 		return err
 	}
 
-	cmd := exec.Command("docker", "login", "-u", dockerUser, "-p", dockerPass)
-	log.Println(viper.GetString("cmdMode"), strings.Join(cmd.Args[:4], " "), " -p ********")
-	if !IsDryRun() {
-		cmd.Stderr = &stderr
-		if cmdOut, err = cmd.Output(); err != nil {
-			err = fmt.Errorf("%v", stderr.String())
-			return err
-		}
-		logCmdOutput(cmdOut)
-	}
+	// registry api client used to compare remote digests before pushing
+	r.newClient(host, user, pass)
 
 	return err
 }
@@ -53,36 +35,20 @@ func (r *Docker) Authenticate() (err error) {
 func (r *Docker) IsRegistryValid() (err error) {
 	if r.Url == "" {
 		err = fmt.Errorf("url missing from %v configuration", r.Description)
+	} else {
+		err = validRepoURL(r.Description, r.Url)
 	}
 	return err
 }
 
-func (docker *Docker) Push(images []string) (pushed []string, err error) {
-	var stderr bytes.Buffer
-	var cmdOut []byte
-
-	for _, image := range images {
-
-		cmd := exec.Command("docker", "push", image)
-		cmd.Stderr = &stderr
-
-		log.Println(viper.GetString("cmdMode"), strings.Join(cmd.Args, " "))
-
-		if !IsDryRun() {
-			if cmdOut, err = cmd.Output(); err != nil {
-				err = fmt.Errorf("%v: %v", image, stderr.String())
-				break
-			}
-
-			logCmdOutput(cmdOut)
-			pushed = append(pushed, image)
-
-		}
-	}
-
-	return pushed, err
+func (docker *Docker) Push(images []string) (result PushResult, err error) {
+	return docker.dockerPush(docker.Url, images)
 }
 
 func (r *Docker) GetRepoURL() (repoURL string) {
 	return r.Url
 }
+
+func (r *Docker) ListTags() ([]string, error) {
+	return listTags(r.client, r.Url)
+}
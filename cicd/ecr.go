@@ -0,0 +1,115 @@
+package cicd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ECR is an Amazon Elastic Container Registry repository.  Credentials come from the standard AWS
+// environment variables and are exchanged for a registry token with the aws cli, unless another source
+// (e.g. the ecr-login credential helper) is declared under Credentials
+type ECR struct {
+	Name        string
+	Description string
+	Account     string
+	Region      string
+	Repo        string
+	Url         string
+	Credentials Credentials
+
+	registryRuntime
+}
+
+// GetRepoURL returns the configured url, or the one implied by account, region and repo
+func (r *ECR) GetRepoURL() (repoURL string) {
+	if r.Url != "" {
+		return r.Url
+	}
+	if r.Account == "" || r.Region == "" || r.Repo == "" {
+		return ""
+	}
+	return fmt.Sprintf("%v.dkr.ecr.%v.amazonaws.com/%v", r.Account, r.Region, r.Repo)
+}
+
+func (r *ECR) ListTags() ([]string, error) {
+	return listTags(r.client, r.GetRepoURL())
+}
+
+func (r *ECR) IsRegistryValid() (err error) {
+	switch {
+	case r.Account == "":
+		err = fmt.Errorf("account missing from %v configuration", r.Description)
+	case r.Region == "":
+		err = fmt.Errorf("region missing from %v configuration", r.Description)
+	case r.GetRepoURL() == "":
+		err = fmt.Errorf("repo or url missing from %v configuration", r.Description)
+	default:
+		err = validRepoURL(r.Description, r.GetRepoURL())
+	}
+	return err
+}
+
+func (r *ECR) Authenticate() (err error) {
+	host, repo := splitRepoURL(r.GetRepoURL())
+
+	var user, pass string
+	if r.Credentials.Source == "" {
+		if user, pass, err = r.awsCredentials(); err == nil {
+			err = dockerLogin(r.executor, host, user, pass)
+		}
+	} else {
+		user, pass, err = r.Credentials.Login(r.executor, host, "ECR_USER", "ECR_PASSWORD")
+	}
+	if err != nil {
+		return err
+	}
+
+	// registry api client used to compare remote digests before pushing
+	r.newClient(host, user, pass)
+
+	return r.ensureRepository(repo)
+}
+
+// awsCredentials exchanges the aws credentials in the environment for a 12 hour registry token
+func (r *ECR) awsCredentials() (user string, token string, err error) {
+	for _, env := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		if os.Getenv(env) == "" {
+			err = fmt.Errorf("%v environment variable not set", env)
+			return user, token, err
+		}
+	}
+
+	var res Result
+	res, err = run(r.executor, Command{
+		Name:  "aws",
+		Args:  []string{"ecr", "get-login-password", "--region", r.Region},
+		Quiet: true,
+	})
+	if err != nil {
+		return user, token, err
+	}
+	return "AWS", strings.TrimSpace(string(res.Stdout)), err
+}
+
+// ensureRepository creates the ECR repository when it does not yet exist; ECR rejects pushes to
+// missing repositories
+func (r *ECR) ensureRepository(repo string) (err error) {
+	_, err = run(r.executor, Command{
+		Name: "aws",
+		Args: []string{"ecr", "describe-repositories", "--region", r.Region, "--registry-id", r.Account, "--repository-names", repo},
+	})
+	if err == nil || !strings.Contains(err.Error(), "RepositoryNotFoundException") {
+		return err
+	}
+
+	_, err = run(r.executor, Command{
+		Name: "aws",
+		Args: []string{"ecr", "create-repository", "--region", r.Region, "--registry-id", r.Account, "--repository-name", repo},
+	})
+	return err
+}
+
+func (r *ECR) Push(images []string) (result PushResult, err error) {
+	return r.dockerPush(r.GetRepoURL(), images)
+}
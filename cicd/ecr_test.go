@@ -0,0 +1,97 @@
+package cicd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestECR(t *testing.T) {
+	const (
+		host  = "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+		image = host + "/team/app:abc123"
+	)
+	aws := func(stdout string, err string, args ...string) Recording {
+		return Recording{Command: Command{Name: "aws", Args: append([]string{"ecr"}, args...)}, Stdout: stdout, Error: err}
+	}
+	token := aws("token\n", "", "get-login-password", "--region", "us-east-1")
+	login := dockerRecording("", "", "login", "-u", "AWS", "--password-stdin", host)
+	describe := func(err string) Recording {
+		return aws("", err, "describe-repositories", "--region", "us-east-1", "--registry-id", "123456789012", "--repository-names", "team/app")
+	}
+	create := aws("", "", "create-repository", "--region", "us-east-1", "--registry-id", "123456789012", "--repository-name", "team/app")
+	push := []Recording{
+		dockerRecording("", "Error: No such image", "image", "inspect", "--format", "{{.Id}}", image),
+		dockerRecording("abc123: digest: "+testDigest1+" size: 528\n", "", "push", image),
+	}
+
+	tests := []struct {
+		name       string
+		secret     string
+		recordings []Recording
+		err        string
+	}{
+		{"existing repository", "secret", append([]Recording{token, login, describe("")}, push...), ""},
+		{"repository created", "secret", append([]Recording{token, login,
+			describe("An error occurred (RepositoryNotFoundException) when calling the DescribeRepositories operation"), create}, push...), ""},
+		{"describe failure", "secret", []Recording{token, login,
+			describe("An error occurred (AccessDeniedException) when calling the DescribeRepositories operation")}, "AccessDeniedException"},
+		{"aws credentials missing", "", nil, "AWS_SECRET_ACCESS_KEY environment variable not set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_ACCESS_KEY_ID", "key")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", tt.secret)
+
+			r := &ECR{Description: "ecr", Account: "123456789012", Region: "us-east-1", Repo: "team/app"}
+			if err := r.IsRegistryValid(); err != nil {
+				t.Fatal(err)
+			}
+			e := NewReplayExecutor(tt.recordings)
+			r.SetExecutor(e)
+
+			err := r.Authenticate()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Authenticate error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c := r.Client(); c.Username != "AWS" || c.Password != "token" {
+				t.Errorf("client credentials = %v, %v", c.Username, c.Password)
+			}
+
+			result, err := r.Push([]string{image})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Pushed) != 1 || result.Pushed[0].Digest != testDigest1 {
+				t.Errorf("pushed %+v", result)
+			}
+			if remaining := e.Remaining(); len(remaining) > 0 {
+				t.Errorf("commands not run: %v", remaining)
+			}
+		})
+	}
+}
+
+func TestECRIsRegistryValid(t *testing.T) {
+	tests := []struct {
+		registry ECR
+		err      string
+	}{
+		{ECR{Account: "123456789012", Region: "us-east-1", Repo: "team/app"}, ""},
+		{ECR{Account: "123456789012", Region: "us-east-1", Url: "123456789012.dkr.ecr.us-east-1.amazonaws.com/app"}, ""},
+		{ECR{Region: "us-east-1", Repo: "team/app"}, "account missing"},
+		{ECR{Account: "123456789012", Repo: "team/app"}, "region missing"},
+		{ECR{Account: "123456789012", Region: "us-east-1"}, "repo or url missing"},
+	}
+	for _, tt := range tests {
+		err := tt.registry.IsRegistryValid()
+		if (tt.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%+v: IsRegistryValid = %v, want %q", tt.registry, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package cicd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// Command describes an external program invocation made on behalf of a provider
+type Command struct {
+	Name string
+	Args []string
+
+	// Stdin is fed to the command; it is never logged or recorded
+	Stdin []byte `yaml:"-"`
+
+	// Secrets are masked wherever the command is logged or recorded
+	Secrets []string `yaml:",omitempty"`
+
+	// Always runs the command in dryrun mode too; used when the tool handles dryrun itself (e.g. helm --dry-run)
+	Always bool `yaml:",omitempty"`
+
+	// Quiet suppresses logging and recording of output that carries credentials (e.g. registry tokens)
+	Quiet bool `yaml:",omitempty"`
+}
+
+// Result holds the captured output of an executed Command
+type Result struct {
+	Stdout []byte
+	Stderr []byte
+}
+
+// Executor runs Commands.  All shell-outs from providers and commands route through an Executor
+type Executor interface {
+	Execute(c Command) (Result, error)
+}
+
+// String renders the command line with secrets masked
+func (c Command) String() string {
+	return c.mask(strings.Join(append([]string{c.Name}, c.Args...), " "))
+}
+
+// mask replaces the command's secrets in s, e.g. in output or errors that echo them back
+func (c Command) mask(s string) string {
+	for _, secret := range c.Secrets {
+		if secret != "" {
+			s = strings.Replace(s, secret, "********", -1)
+		}
+	}
+	return s
+}
+
+// ShellExecutor runs commands on the local host
+type ShellExecutor struct{}
+
+func NewShellExecutor() *ShellExecutor {
+	return &ShellExecutor{}
+}
+
+func (e *ShellExecutor) Execute(c Command) (res Result, err error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(c.Name, c.Args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if c.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(c.Stdin)
+	}
+
+	log.Println("execute:", c)
+
+	// output is logged as one block per command so concurrent commands don't interleave
+	err = cmd.Run()
+	res = Result{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+	if err != nil {
+		logCmdBlock(c, res.Stderr)
+		return res, fmt.Errorf("%v", c.mask(stderr.String()))
+	}
+
+	if !c.Quiet {
+		logCmdBlock(c, res.Stdout)
+	}
+	return res, err
+}
+
+// DryRunExecutor logs commands without running them, except those marked Always which are passed to Next
+type DryRunExecutor struct {
+	Next Executor
+}
+
+func NewDryRunExecutor(next Executor) *DryRunExecutor {
+	return &DryRunExecutor{Next: next}
+}
+
+func (e *DryRunExecutor) Execute(c Command) (res Result, err error) {
+	log.Println("dryrun:", c)
+	if c.Always && e.Next != nil {
+		return e.Next.Execute(c)
+	}
+	return res, err
+}
+
+func logCmdOutput(cmdOut []byte) {
+	if len(bytes.TrimSpace(cmdOut)) == 0 {
+		return
+	}
+	for _, o := range strings.Split(strings.TrimSpace(string(cmdOut)), "\n") {
+		log.Println(o)
+	}
+}
+
+// logCmdBlock logs command output in a single write, each line indented beneath the command
+func logCmdBlock(c Command, cmdOut []byte) {
+	out := strings.TrimSpace(c.mask(string(cmdOut)))
+	if out == "" {
+		return
+	}
+	log.Printf("output: %v\n    %v\n", c.Name, strings.Replace(out, "\n", "\n    ", -1))
+}
+
+// run executes c with e, falling back to the shell executor for providers not activated through a Workflow
+func run(e Executor, c Command) (Result, error) {
+	if e == nil {
+		e = NewShellExecutor()
+	}
+	return e.Execute(c)
+}
@@ -0,0 +1,39 @@
+package cicd
+
+import (
+	"testing"
+)
+
+func TestCommandString(t *testing.T) {
+	tests := []struct {
+		c    Command
+		want string
+	}{
+		{Command{Name: "docker", Args: []string{"push", "app:abc"}}, "docker push app:abc"},
+		{Command{Name: "curl", Args: []string{"-u", "user:s3cret", "https://s3cret.example.com"}, Secrets: []string{"s3cret"}}, "curl -u user:******** https://********.example.com"},
+		{Command{Name: "login", Args: []string{"--token", "abc", "--key", "xyz"}, Secrets: []string{"abc", "", "xyz"}}, "login --token ******** --key ********"},
+		{Command{Name: "echo", Args: []string{"secret"}, Secrets: []string{""}}, "echo secret"},
+	}
+	for _, tt := range tests {
+		if got := tt.c.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestDryRunExecutor(t *testing.T) {
+	helm := Command{Name: "helm", Args: []string{"upgrade", "--dry-run"}, Always: true}
+	next := NewReplayExecutor([]Recording{{Command: helm, Stdout: "rendered"}})
+	e := NewDryRunExecutor(next)
+
+	// commands are skipped unless the tool handles dryrun itself
+	if res, err := e.Execute(Command{Name: "docker", Args: []string{"push", "app:abc"}}); err != nil || len(res.Stdout) != 0 {
+		t.Errorf("dryrun docker push = %q, %v", res.Stdout, err)
+	}
+	if res, err := e.Execute(helm); err != nil || string(res.Stdout) != "rendered" {
+		t.Errorf("dryrun helm = %q, %v", res.Stdout, err)
+	}
+	if remaining := next.Remaining(); len(remaining) > 0 {
+		t.Errorf("commands not run: %v", remaining)
+	}
+}
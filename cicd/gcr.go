@@ -1,16 +1,14 @@
 package cicd
 
 import (
-	"bytes"
 	"fmt"
-	"log"
+	"io/ioutil"
 	"os"
-	"os/exec"
-	"strings"
-
-	"github.com/spf13/viper"
 )
 
+// GCR is a Google Container Registry repository.  By default the service account Keyfile is activated
+// with gcloud, which then pushes; when another source is declared under Credentials the docker cli is
+// logged in with those and pushes instead
 type GCR struct {
 	Name        string
 	Description string
@@ -19,67 +17,71 @@ type GCR struct {
 	Repo        string
 	Url         string
 	Keyfile     string
+	Credentials Credentials
+
+	registryRuntime
 }
 
 func (r *GCR) GetRepoURL() (repoURL string) {
 	return r.Url
 }
 
+func (r *GCR) ListTags() ([]string, error) {
+	return listTags(r.client, r.Url)
+}
+
 func (r *GCR) Authenticate() (err error) {
-	var stderr bytes.Buffer
+	host, _ := splitRepoURL(r.Url)
+
+	if r.Credentials.Source != "" {
+		var user, pass string
+		if user, pass, err = r.Credentials.Login(r.executor, host, "GCR_USER", "GCR_PASSWORD"); err != nil {
+			return err
+		}
+		r.newClient(host, user, pass)
+		return err
+	}
 
 	if _, err = os.Stat(r.Keyfile); os.IsNotExist(err) {
 		err = fmt.Errorf("gcloud auth key: %v", err)
 		return err
 	}
 
-	cmd := exec.Command("gcloud", "auth", "activate-service-account", "--key-file", r.Keyfile)
-	cmd.Stderr = &stderr
+	var res Result
+	res, err = run(r.executor, Command{Name: "gcloud", Args: []string{"auth", "activate-service-account", "--key-file", r.Keyfile}})
+	if err != nil {
+		return err
+	}
+	// BUG: gcloud returning successful result over stderr (why?)
+	logCmdOutput(res.Stderr)
 
-	log.Println(viper.GetString("cmdMode"), strings.Join(cmd.Args, " "))
-	if !IsDryRun() {
-		if err = cmd.Run(); err != nil {
-			logCmdOutput(stderr.Bytes())
-			err = fmt.Errorf("%v", stderr.String())
-			return err
-		}
-		// BUG: gcloud returning successful result over stderr (why?)
-		logCmdOutput(stderr.Bytes())
+	// registry api client used to compare remote digests before pushing; gcr accepts the service
+	// account key as basic credentials
+	var key []byte
+	if key, err = ioutil.ReadFile(r.Keyfile); err != nil {
+		return err
 	}
+	r.newClient(host, "_json_key", string(key))
 
 	return err
 
 }
 
-func (gcr *GCR) Push(images []string) (pushed []string, err error) {
-	var stderr bytes.Buffer
-	var cmdOut []byte
-
-	for _, image := range images {
-
-		cmd := exec.Command("gcloud", "docker", "--", "push", image)
-		cmd.Stderr = &stderr
-
-		log.Println(viper.GetString("cmdMode"), strings.Join(cmd.Args, " "))
-
-		if !IsDryRun() {
-			if cmdOut, err = cmd.Output(); err != nil {
-				logCmdOutput(stderr.Bytes())
-				err = fmt.Errorf("%v: %v", image, stderr.String())
-				break
-			}
-			pushed = append(pushed, image)
-			logCmdOutput(cmdOut)
+func (gcr *GCR) Push(images []string) (result PushResult, err error) {
+	return cliPush(gcr.executor, gcr.client, gcr.Url, images, gcr.concurrency, func(image string) Command {
+		if gcr.Credentials.Source != "" {
+			return Command{Name: "docker", Args: []string{"push", image}}
 		}
-	}
-
-	return pushed, err
+		return Command{Name: "gcloud", Args: []string{"docker", "--", "push", image}}
+	})
 }
 
 func (r *GCR) IsRegistryValid() (err error) {
 	// TODO: check existence of other required field and/or remove unnecessary (host, account/project, repo)
 	if r.Url == "" {
 		err = fmt.Errorf("registry url missing from %v configuration", r.Description)
+	} else {
+		err = validRepoURL(r.Description, r.Url)
 	}
 	return err
 }
@@ -0,0 +1,101 @@
+package cicd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+type GitHub struct {
+	Name string
+}
+
+// githubEvent holds the parts of the GITHUB_EVENT_PATH payload used to describe the build
+type githubEvent struct {
+	Number      int
+	PullRequest struct {
+		Number int
+		Head   struct {
+			Ref string
+		}
+	} `json:"pull_request"`
+	Release struct {
+		TagName string `json:"tag_name"`
+	}
+}
+
+// BuildInfo reads the GitHub Actions environment and event payload.  pull_request events report the
+// head branch and PR number, release events the release tag, and push or workflow_dispatch runs of a
+// tag ref the tag event; other push and workflow_dispatch runs are pushes of the ref branch
+func (g *GitHub) BuildInfo() (bi BuildInfo, err error) {
+	if os.Getenv("GITHUB_ACTIONS") != "true" {
+		log.Println("github: not running under github actions; using flags")
+		return bi, err
+	}
+
+	bi.Commit = os.Getenv("GITHUB_SHA")
+	bi.Build = os.Getenv("GITHUB_RUN_NUMBER")
+
+	ref := os.Getenv("GITHUB_REF")
+	if strings.HasPrefix(ref, "refs/heads/") {
+		bi.Branch = strings.TrimPrefix(ref, "refs/heads/")
+	}
+	var refTag string
+	if strings.HasPrefix(ref, "refs/tags/") {
+		refTag = strings.TrimPrefix(ref, "refs/tags/")
+	}
+
+	var ev githubEvent
+	if path := os.Getenv("GITHUB_EVENT_PATH"); path != "" {
+		var b []byte
+		if b, err = ioutil.ReadFile(path); err != nil {
+			return bi, fmt.Errorf("github event payload: %v", err)
+		}
+		if err = json.Unmarshal(b, &ev); err != nil {
+			return bi, fmt.Errorf("github event payload %v: %v", path, err)
+		}
+	}
+
+	switch name := os.Getenv("GITHUB_EVENT_NAME"); name {
+	case "pull_request", "pull_request_target":
+		bi.Event = "pull_request"
+		bi.Branch = os.Getenv("GITHUB_HEAD_REF")
+		if bi.Branch == "" {
+			bi.Branch = ev.PullRequest.Head.Ref
+		}
+
+		switch {
+		case ev.PullRequest.Number != 0:
+			bi.PR = fmt.Sprint(ev.PullRequest.Number)
+		case ev.Number != 0:
+			bi.PR = fmt.Sprint(ev.Number)
+		default:
+			// refs/pull/<n>/merge
+			if parts := strings.Split(ref, "/"); len(parts) == 4 && parts[1] == "pull" {
+				bi.PR = parts[2]
+			}
+		}
+
+	case "release":
+		bi.Event = "release"
+		bi.Tag = ev.Release.TagName
+		if bi.Tag == "" {
+			bi.Tag = refTag
+		}
+
+	case "push", "workflow_dispatch":
+		if refTag != "" {
+			bi.Event, bi.Tag = "tag", refTag
+		} else {
+			bi.Event = "push"
+		}
+
+	default:
+		log.Printf("github: event %v treated as push\n", name)
+		bi.Event = "push"
+	}
+	return bi, err
+}
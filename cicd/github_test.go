@@ -0,0 +1,87 @@
+package cicd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitHubBuildInfo(t *testing.T) {
+	keys := []string{"GITHUB_ACTIONS", "GITHUB_SHA", "GITHUB_RUN_NUMBER", "GITHUB_REF", "GITHUB_HEAD_REF",
+		"GITHUB_EVENT_NAME", "GITHUB_EVENT_PATH"}
+	const (
+		pullRequest = `{"number":7,"pull_request":{"number":7,"head":{"ref":"feature/x"}}}`
+		release     = `{"release":{"tag_name":"v2.0.0"}}`
+	)
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		payload string
+		want    BuildInfo
+	}{
+		{"not github", map[string]string{"GITHUB_REF": "refs/heads/main"}, "", BuildInfo{}},
+		{"push", map[string]string{"GITHUB_EVENT_NAME": "push", "GITHUB_REF": "refs/heads/main"}, `{}`,
+			BuildInfo{Branch: "main", Event: "push"}},
+		{"push of a tag", map[string]string{"GITHUB_EVENT_NAME": "push", "GITHUB_REF": "refs/tags/v1.0.0"}, `{}`,
+			BuildInfo{Event: "tag", Tag: "v1.0.0"}},
+		{"pull request", map[string]string{"GITHUB_EVENT_NAME": "pull_request", "GITHUB_REF": "refs/pull/7/merge",
+			"GITHUB_HEAD_REF": "feature/x"}, pullRequest,
+			BuildInfo{Branch: "feature/x", Event: "pull_request", PR: "7"}},
+		{"pull request from the payload", map[string]string{"GITHUB_EVENT_NAME": "pull_request_target",
+			"GITHUB_REF": "refs/heads/main"}, pullRequest,
+			BuildInfo{Branch: "feature/x", Event: "pull_request", PR: "7"}},
+		{"pull request from the ref", map[string]string{"GITHUB_EVENT_NAME": "pull_request", "GITHUB_REF": "refs/pull/9/merge",
+			"GITHUB_HEAD_REF": "fix"}, `{}`,
+			BuildInfo{Branch: "fix", Event: "pull_request", PR: "9"}},
+		{"release", map[string]string{"GITHUB_EVENT_NAME": "release", "GITHUB_REF": "refs/tags/v2.0.0-rc.1"}, release,
+			BuildInfo{Event: "release", Tag: "v2.0.0"}},
+		{"release without payload tag", map[string]string{"GITHUB_EVENT_NAME": "release", "GITHUB_REF": "refs/tags/v2.0.0"}, `{}`,
+			BuildInfo{Event: "release", Tag: "v2.0.0"}},
+		{"workflow dispatch", map[string]string{"GITHUB_EVENT_NAME": "workflow_dispatch", "GITHUB_REF": "refs/heads/dev"}, `{}`,
+			BuildInfo{Branch: "dev", Event: "push"}},
+		{"workflow dispatch of a tag", map[string]string{"GITHUB_EVENT_NAME": "workflow_dispatch", "GITHUB_REF": "refs/tags/v1.1.0"}, `{}`,
+			BuildInfo{Event: "tag", Tag: "v1.1.0"}},
+		{"schedule", map[string]string{"GITHUB_EVENT_NAME": "schedule", "GITHUB_REF": "refs/heads/main"}, `{}`,
+			BuildInfo{Branch: "main", Event: "push"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			want := tt.want
+			if tt.payload != "" {
+				env["GITHUB_ACTIONS"] = "true"
+				env["GITHUB_SHA"] = "abc123"
+				env["GITHUB_RUN_NUMBER"] = "42"
+				env["GITHUB_EVENT_PATH"] = filepath.Join(t.TempDir(), "event.json")
+				writeTestFile(t, env["GITHUB_EVENT_PATH"], tt.payload)
+				want.Commit, want.Build = "abc123", "42"
+			}
+			setTestEnv(t, keys, env)
+
+			bi, err := (&GitHub{}).BuildInfo()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bi != want {
+				t.Errorf("BuildInfo = %+v, want %+v", bi, want)
+			}
+		})
+	}
+}
+
+func TestGitHubBuildInfoPayloadErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "bad.json"), "{")
+
+	for _, path := range []string{filepath.Join(dir, "missing.json"), filepath.Join(dir, "bad.json")} {
+		setTestEnv(t, []string{"GITHUB_ACTIONS", "GITHUB_EVENT_NAME", "GITHUB_EVENT_PATH"},
+			map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_EVENT_NAME": "push", "GITHUB_EVENT_PATH": path})
+		if _, err := (&GitHub{}).BuildInfo(); err == nil || !strings.Contains(err.Error(), "github event payload") {
+			t.Errorf("%v: BuildInfo error = %v", filepath.Base(path), err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package cicd
+
+import (
+	"log"
+	"os"
+)
+
+type GitLab struct {
+	Name string
+}
+
+// BuildInfo reads the GitLab CI environment.  Merge request pipelines report the source branch and
+// merge request IID as a pull_request, tag pipelines the tag event; pipelines from any other source are
+// pushes of CI_COMMIT_REF_NAME
+func (g *GitLab) BuildInfo() (bi BuildInfo, err error) {
+	if os.Getenv("GITLAB_CI") != "true" {
+		log.Println("gitlab: not running under gitlab ci; using flags")
+		return bi, err
+	}
+
+	bi.Branch = os.Getenv("CI_COMMIT_REF_NAME")
+	bi.Commit = os.Getenv("CI_COMMIT_SHA")
+	bi.Build = os.Getenv("CI_PIPELINE_IID")
+	bi.Tag = os.Getenv("CI_COMMIT_TAG")
+
+	switch {
+	case os.Getenv("CI_PIPELINE_SOURCE") == "merge_request_event":
+		bi.Event = "pull_request"
+		bi.PR = os.Getenv("CI_MERGE_REQUEST_IID")
+		if b := os.Getenv("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME"); b != "" {
+			bi.Branch = b
+		}
+	case bi.Tag != "":
+		bi.Event = "tag"
+		bi.Branch = ""
+	default:
+		bi.Event = "push"
+	}
+	return bi, err
+}
@@ -0,0 +1,47 @@
+package cicd
+
+import (
+	"testing"
+)
+
+func TestGitLabBuildInfo(t *testing.T) {
+	keys := []string{"GITLAB_CI", "CI_COMMIT_REF_NAME", "CI_COMMIT_SHA", "CI_PIPELINE_IID", "CI_COMMIT_TAG",
+		"CI_PIPELINE_SOURCE", "CI_MERGE_REQUEST_IID", "CI_MERGE_REQUEST_SOURCE_BRANCH_NAME"}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want BuildInfo
+	}{
+		{"not gitlab", map[string]string{"CI_COMMIT_REF_NAME": "main"}, BuildInfo{}},
+		{"push", map[string]string{"CI_COMMIT_REF_NAME": "main", "CI_PIPELINE_SOURCE": "push"},
+			BuildInfo{Branch: "main", Event: "push"}},
+		{"scheduled", map[string]string{"CI_COMMIT_REF_NAME": "main", "CI_PIPELINE_SOURCE": "schedule"},
+			BuildInfo{Branch: "main", Event: "push"}},
+		{"merge request", map[string]string{"CI_COMMIT_REF_NAME": "feature/x", "CI_PIPELINE_SOURCE": "merge_request_event",
+			"CI_MERGE_REQUEST_IID": "7", "CI_MERGE_REQUEST_SOURCE_BRANCH_NAME": "feature/y"},
+			BuildInfo{Branch: "feature/y", Event: "pull_request", PR: "7"}},
+		{"tag", map[string]string{"CI_COMMIT_REF_NAME": "v1.2.0", "CI_PIPELINE_SOURCE": "push", "CI_COMMIT_TAG": "v1.2.0"},
+			BuildInfo{Event: "tag", Tag: "v1.2.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want != (BuildInfo{}) {
+				tt.env["GITLAB_CI"] = "true"
+				tt.env["CI_COMMIT_SHA"] = "abc123"
+				tt.env["CI_PIPELINE_IID"] = "42"
+				want.Commit, want.Build = "abc123", "42"
+			}
+			setTestEnv(t, keys, tt.env)
+
+			bi, err := (&GitLab{}).BuildInfo()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bi != want {
+				t.Errorf("BuildInfo = %+v, want %+v", bi, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,478 @@
+package cicd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// gitRepo reads a repository's .git directory directly, without the git binary or network access.
+// Refs, packed-refs and loose and packed objects (including deltas) are supported
+type gitRepo struct {
+	worktree string
+	gitDir   string
+
+	// common holds refs and objects shared by linked worktrees; it is gitDir otherwise
+	common string
+
+	packs []*gitPack
+}
+
+// openGitRepo finds the repository containing dir by walking up to the first .git directory or file
+func openGitRepo(dir string) (g *gitRepo, err error) {
+	if dir, err = filepath.Abs(dir); err != nil {
+		return g, err
+	}
+
+	for d := dir; ; d = filepath.Dir(d) {
+		dotgit := filepath.Join(d, ".git")
+		if fi, serr := os.Stat(dotgit); serr == nil {
+			g = &gitRepo{worktree: d, gitDir: dotgit}
+			if !fi.IsDir() {
+				// linked worktrees and submodules: .git is a file naming the git directory
+				var b []byte
+				if b, err = ioutil.ReadFile(dotgit); err != nil {
+					return nil, err
+				}
+				gd := strings.TrimSpace(strings.TrimPrefix(string(b), "gitdir:"))
+				if !filepath.IsAbs(gd) {
+					gd = filepath.Join(d, gd)
+				}
+				g.gitDir = gd
+			}
+			break
+		}
+		if filepath.Dir(d) == d {
+			return nil, fmt.Errorf("no git repository found in %v or its parents", dir)
+		}
+	}
+
+	g.common = g.gitDir
+	if b, rerr := ioutil.ReadFile(filepath.Join(g.gitDir, "commondir")); rerr == nil {
+		cd := strings.TrimSpace(string(b))
+		if !filepath.IsAbs(cd) {
+			cd = filepath.Join(g.gitDir, cd)
+		}
+		g.common = cd
+	}
+	return g, err
+}
+
+// head returns the checked out branch (empty when detached) and commit
+func (g *gitRepo) head() (branch string, commit string, err error) {
+	b, err := ioutil.ReadFile(filepath.Join(g.gitDir, "HEAD"))
+	if err != nil {
+		return branch, commit, err
+	}
+
+	head := strings.TrimSpace(string(b))
+	if !strings.HasPrefix(head, "ref: ") {
+		return branch, head, err
+	}
+
+	ref := strings.TrimPrefix(head, "ref: ")
+	branch = strings.TrimPrefix(ref, "refs/heads/")
+	if commit, err = g.resolveRef(ref); err != nil {
+		return branch, commit, err
+	}
+	return branch, commit, err
+}
+
+// resolveRef returns the object a loose or packed ref points at, or "" for an unborn branch
+func (g *gitRepo) resolveRef(ref string) (sha string, err error) {
+	for _, dir := range []string{g.gitDir, g.common} {
+		if b, rerr := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(ref))); rerr == nil {
+			sha = strings.TrimSpace(string(b))
+			if strings.HasPrefix(sha, "ref: ") {
+				return g.resolveRef(strings.TrimPrefix(sha, "ref: "))
+			}
+			return sha, err
+		}
+	}
+
+	refs, _, err := g.packedRefs()
+	return refs[ref], err
+}
+
+// packedRefs parses packed-refs into ref targets and the peeled commits of annotated tags
+func (g *gitRepo) packedRefs() (refs map[string]string, peeled map[string]string, err error) {
+	refs, peeled = map[string]string{}, map[string]string{}
+
+	f, err := os.Open(filepath.Join(g.common, "packed-refs"))
+	if os.IsNotExist(err) {
+		return refs, peeled, nil
+	} else if err != nil {
+		return refs, peeled, err
+	}
+	defer f.Close()
+
+	var last string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "^"):
+			peeled[last] = strings.TrimPrefix(line, "^")
+		default:
+			if fields := strings.Fields(line); len(fields) == 2 {
+				refs[fields[1]] = fields[0]
+				last = fields[1]
+			}
+		}
+	}
+	return refs, peeled, s.Err()
+}
+
+// tags maps each commit to the names of the tags pointing at it, peeling annotated tags
+func (g *gitRepo) tags() (byCommit map[string][]string, err error) {
+	refs, peeled, err := g.packedRefs()
+	if err != nil {
+		return byCommit, err
+	}
+
+	tagDir := filepath.Join(g.common, "refs", "tags")
+	err = filepath.Walk(tagDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(g.common, path)
+		ref := filepath.ToSlash(rel)
+		refs[ref] = strings.TrimSpace(string(b))
+		delete(peeled, ref)
+		return nil
+	})
+	if err != nil {
+		return byCommit, err
+	}
+
+	byCommit = map[string][]string{}
+	for ref, sha := range refs {
+		if !strings.HasPrefix(ref, "refs/tags/") {
+			continue
+		}
+		if p, ok := peeled[ref]; ok {
+			sha = p
+		} else if sha, err = g.peel(sha); err != nil {
+			return byCommit, err
+		}
+		byCommit[sha] = append(byCommit[sha], strings.TrimPrefix(ref, "refs/tags/"))
+	}
+	return byCommit, err
+}
+
+// peel follows annotated tag objects to the object they tag
+func (g *gitRepo) peel(sha string) (string, error) {
+	for {
+		typ, data, err := g.readObject(sha)
+		if err != nil || typ != "tag" {
+			return sha, err
+		}
+		sha = headerField(data, "object")
+	}
+}
+
+// tagAt returns the tag pointing at commit, the highest version when there are several
+func (g *gitRepo) tagAt(commit string) (tag string, err error) {
+	byCommit, err := g.tags()
+	names := byCommit[commit]
+	if err != nil || len(names) == 0 {
+		return tag, err
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		vi, ei := ParseSemver(names[i])
+		vj, ej := ParseSemver(names[j])
+		if ei == nil && ej == nil {
+			return vj.Less(vi)
+		}
+		return ei == nil || (ej != nil && names[i] > names[j])
+	})
+	return names[0], err
+}
+
+// readObject returns the type and content of a loose or packed object
+func (g *gitRepo) readObject(sha string) (typ string, data []byte, err error) {
+	if len(sha) != 40 {
+		return typ, data, fmt.Errorf("invalid git object id %q", sha)
+	}
+
+	f, err := os.Open(filepath.Join(g.common, "objects", sha[:2], sha[2:]))
+	if err == nil {
+		defer f.Close()
+		var zr io.ReadCloser
+		if zr, err = zlib.NewReader(f); err != nil {
+			return typ, data, err
+		}
+		defer zr.Close()
+		var raw []byte
+		if raw, err = ioutil.ReadAll(zr); err != nil {
+			return typ, data, err
+		}
+		nul := bytes.IndexByte(raw, 0)
+		sp := bytes.IndexByte(raw, ' ')
+		if nul < 0 || sp < 0 || sp > nul {
+			return typ, data, fmt.Errorf("git object %v: malformed header", sha)
+		}
+		return string(raw[:sp]), raw[nul+1:], nil
+	} else if !os.IsNotExist(err) {
+		return typ, data, err
+	}
+
+	if err = g.loadPacks(); err != nil {
+		return typ, data, err
+	}
+	id, _ := hex.DecodeString(sha)
+	for _, p := range g.packs {
+		if off, ok := p.find(id); ok {
+			return p.read(g, off)
+		}
+	}
+	return typ, data, fmt.Errorf("git object %v not found", sha)
+}
+
+func (g *gitRepo) loadPacks() (err error) {
+	if g.packs != nil {
+		return err
+	}
+	g.packs = []*gitPack{}
+
+	idxs, err := filepath.Glob(filepath.Join(g.common, "objects", "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+	for _, idx := range idxs {
+		var p *gitPack
+		if p, err = openGitPack(idx); err != nil {
+			return err
+		}
+		g.packs = append(g.packs, p)
+	}
+	return err
+}
+
+// gitPack is a packfile with its version 2 index
+type gitPack struct {
+	path    string
+	fanout  [256]uint32
+	ids     []byte
+	offsets []byte
+	large   []byte
+}
+
+func openGitPack(idx string) (p *gitPack, err error) {
+	b, err := ioutil.ReadFile(idx)
+	if err != nil {
+		return p, err
+	}
+	if len(b) < 8+256*4 || !bytes.Equal(b[:4], []byte{0xff, 't', 'O', 'c'}) || binary.BigEndian.Uint32(b[4:]) != 2 {
+		return p, fmt.Errorf("git pack index %v: unsupported format", idx)
+	}
+
+	p = &gitPack{path: strings.TrimSuffix(idx, ".idx") + ".pack"}
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(b[8+4*i:])
+	}
+	n := int(p.fanout[255])
+	base := 8 + 256*4
+	if len(b) < base+n*28 {
+		return nil, fmt.Errorf("git pack index %v: truncated", idx)
+	}
+	p.ids = b[base : base+n*20]
+	p.offsets = b[base+n*24 : base+n*28]
+	p.large = b[base+n*28:]
+	return p, err
+}
+
+// find returns the pack offset of object id
+func (p *gitPack) find(id []byte) (off int64, ok bool) {
+	lo := 0
+	if id[0] > 0 {
+		lo = int(p.fanout[id[0]-1])
+	}
+	hi := int(p.fanout[id[0]])
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.ids[(lo+i)*20:(lo+i+1)*20], id) >= 0
+	})
+	if i >= hi || !bytes.Equal(p.ids[i*20:(i+1)*20], id) {
+		return off, false
+	}
+
+	o := binary.BigEndian.Uint32(p.offsets[i*4:])
+	if o&0x80000000 != 0 {
+		j := int(o &^ 0x80000000)
+		return int64(binary.BigEndian.Uint64(p.large[j*8:])), true
+	}
+	return int64(o), true
+}
+
+var gitPackTypes = map[byte]string{1: "commit", 2: "tree", 3: "blob", 4: "tag"}
+
+// read inflates the object at off, resolving offset and reference deltas against their bases
+func (p *gitPack) read(g *gitRepo, off int64) (typ string, data []byte, err error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return typ, data, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(io.NewSectionReader(f, off, 1<<62))
+	c, err := r.ReadByte()
+	if err != nil {
+		return typ, data, err
+	}
+	kind := (c >> 4) & 7
+	size := int64(c & 15)
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if c, err = r.ReadByte(); err != nil {
+			return typ, data, err
+		}
+		size |= int64(c&0x7f) << shift
+	}
+
+	var baseType string
+	var base []byte
+	switch kind {
+	case 6:
+		// offset delta: base is earlier in this pack
+		var rel int64
+		if c, err = r.ReadByte(); err != nil {
+			return typ, data, err
+		}
+		rel = int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = r.ReadByte(); err != nil {
+				return typ, data, err
+			}
+			rel = (rel+1)<<7 | int64(c&0x7f)
+		}
+		if baseType, base, err = p.read(g, off-rel); err != nil {
+			return typ, data, err
+		}
+	case 7:
+		// reference delta: base named by id
+		id := make([]byte, 20)
+		if _, err = io.ReadFull(r, id); err != nil {
+			return typ, data, err
+		}
+		if baseType, base, err = g.readObject(hex.EncodeToString(id)); err != nil {
+			return typ, data, err
+		}
+	}
+
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return typ, data, err
+	}
+	defer zr.Close()
+	data = make([]byte, size)
+	if _, err = io.ReadFull(zr, data); err != nil {
+		return typ, data, fmt.Errorf("git pack %v: %v", p.path, err)
+	}
+
+	if base != nil {
+		data, err = applyGitDelta(base, data)
+		return baseType, data, err
+	}
+	if typ = gitPackTypes[kind]; typ == "" {
+		return typ, data, fmt.Errorf("git pack %v: unknown object type %d", p.path, kind)
+	}
+	return typ, data, err
+}
+
+// applyGitDelta rebuilds an object from its base and a delta of copy and insert instructions
+func applyGitDelta(base []byte, delta []byte) (out []byte, err error) {
+	malformed := fmt.Errorf("git pack: malformed delta")
+
+	size := func() (n int) {
+		for shift := uint(0); len(delta) > 0; shift += 7 {
+			c := delta[0]
+			delta = delta[1:]
+			n |= int(c&0x7f) << shift
+			if c&0x80 == 0 {
+				break
+			}
+		}
+		return n
+	}
+	if size() != len(base) {
+		return out, malformed
+	}
+	out = make([]byte, 0, size())
+
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		switch {
+		case op&0x80 != 0:
+			var off, n int
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return out, malformed
+				}
+				if i < 4 {
+					off |= int(delta[0]) << (8 * i)
+				} else {
+					n |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if off+n > len(base) {
+				return out, malformed
+			}
+			out = append(out, base[off:off+n]...)
+		case op != 0:
+			if int(op) > len(delta) {
+				return out, malformed
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return out, malformed
+		}
+	}
+	return out, err
+}
+
+// headerField returns the first value of a commit or tag object header
+func headerField(data []byte, key string) string {
+	if v := headerFields(data, key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func headerFields(data []byte, key string) (values []string) {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, key+" ") {
+			values = append(values, strings.TrimPrefix(line, key+" "))
+		}
+	}
+	return values
+}
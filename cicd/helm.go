@@ -7,10 +7,6 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
-	"strings"
-
-	"github.com/spf13/viper"
 )
 
 type Helm struct {
@@ -20,29 +16,35 @@ type Helm struct {
 	Namespace string
 	Chartpath string
 	Values    struct {
-		Template string
-		Output   string
+		Template  string
+		Output    string
 		Overrides struct {
 			Platform map[string]map[string]string
 		}
 	}
+
+	executor Executor
 }
 
-func (h *Helm) Deploy(wf *Workflow) (err error) {
+func (h *Helm) SetExecutor(e Executor) {
+	h.executor = e
+}
+
+func (h *Helm) Deploy(wf *Workflow, opts Options) (err error) {
 
 	// create helm release name
-	release := viper.GetString("service") + "-" + viper.GetString("branch")
+	release := ReleaseName(opts.Service, opts.Branch)
 
 	// helm required flags
-	args := []string{"--install", release, "--namespace", viper.GetString("namespace")}
+	args := []string{"--install", release, "--namespace", opts.Namespace}
 
 	// cli flag conversion
-	if IsDebug() {
+	if wf.IsDebug() {
 		args = append(args, "--debug")
 	}
 
 	// convert cicd --dryrun arg to helm dialect
-	if IsDryRun() {
+	if wf.IsDryRun() {
 		args = append(args, "--dry-run")
 	}
 
@@ -71,10 +73,10 @@ func (h *Helm) Deploy(wf *Workflow) (err error) {
 
 	// render values file using template
 	err = renderHelmValuesFile(
+		wf,
 		valuesFile,
-		viper.GetString("repo"),
-		viper.GetString("tag"),
-		serviceType,
+		opts.Template,
+		helmValues{Repo: opts.Repo, Tag: opts.Tag, Digest: opts.Digest, ServiceType: serviceType},
 	)
 
 	if err != nil {
@@ -83,49 +85,54 @@ func (h *Helm) Deploy(wf *Workflow) (err error) {
 
 	// join flags and positional args
 	args = append(args, "--values", valuesFile.Name())
-	args = append(args, viper.GetString("chart"))
-
-	// init command vars
-	var stderr bytes.Buffer
-	var cmdOut []byte
+	args = append(args, opts.Chart)
 
 	// prepend subcommand deploy to args
 	args = append([]string{"upgrade"}, args...)
-	cmd := exec.Command("helm", args...)
-
-	log.Println(viper.GetString("cmdMode"), strings.Join(cmd.Args, " "))
-
-	// execute helm command
-	cmd.Stderr = &stderr
-	if cmdOut, err = cmd.Output(); err != nil {
-		logCmdOutput(stderr.Bytes())
-		err = fmt.Errorf("%v", stderr.String())
-	} else {
-		logCmdOutput(cmdOut)
-	}
 
+	// execute helm command; helm handles dryrun itself via --dry-run
+	_, err = run(h.executor, Command{Name: "helm", Args: args, Always: true})
 	return err
 }
 
-func renderHelmValuesFile(valuesFile *os.File, repo string, tag string, serviceType string) error {
+// helmValues are the fields available to the helm values template.  Image is the full image reference,
+// pinned as repo@digest when a digest is known and repo:tag otherwise
+type helmValues struct {
+	Repo, Tag, Digest, Image, ServiceType string
+}
 
-	type Values struct {
-		Repo, Tag, ServiceType string
-	}
+func renderHelmValuesFile(wf *Workflow, valuesFile *os.File, tpl string, values helmValues) error {
 
 	// Prepare some data to insert into the template.
-	var values = Values{Repo: repo, Tag: tag, ServiceType: serviceType}
+	values.Image = values.Repo + ":" + values.Tag
+	if values.Digest != "" {
+		values.Image = values.Repo + "@" + values.Digest
+		log.Println("helm image pinned by digest:", values.Image)
+	}
 
 	// initialize the template
 	var t *template.Template
 	var err error
-	if t, err = template.ParseFiles(viper.GetString("template")); err != nil {
+	if t, err = template.ParseFiles(tpl); err != nil {
 		return err
 	}
 
 	// render the template
+	var rendered bytes.Buffer
+	if err = t.Execute(&rendered, values); err != nil {
+		return err
+	}
+
+	// values rendering only repo and tag would deploy the mutable tag rather than the pinned digest.  The
+	// rendered output is checked rather than the template so conditionals cannot hide an unpinned image
+	if values.Digest != "" && !bytes.Contains(rendered.Bytes(), []byte(values.Digest)) {
+		return fmt.Errorf("helm values rendered from %v do not contain digest %v; refusing to deploy %v:%v unpinned", tpl, values.Digest, values.Repo, values.Tag)
+	}
+
 	log.Println("helm runtime values filename: ", valuesFile.Name())
-	err = t.Execute(valuesFile, values)
+	if _, err = valuesFile.Write(rendered.Bytes()); err != nil {
+		return err
+	}
 
 	// verify rendered file contents
 	yaml, err := ioutil.ReadFile(valuesFile.Name())
@@ -134,7 +141,7 @@ func renderHelmValuesFile(valuesFile *os.File, repo string, tag string, serviceT
 		return err
 	}
 
-	LogDebug(fmt.Sprintf("helm runtime values: \n%v", string(yaml)))
+	wf.LogDebug(fmt.Sprintf("helm runtime values: \n%v", string(yaml)))
 
 	return err
 }
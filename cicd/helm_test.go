@@ -0,0 +1,49 @@
+package cicd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderHelmValuesPinned(t *testing.T) {
+	tests := []struct {
+		text string
+		ok   bool
+	}{
+		{"image: {{.Repo}}:{{.Tag}}", false},
+		{"image: {{.Image}}", true},
+		{"image: {{.Repo}}@{{.Digest}}", true},
+		{"image:\n  repository: {{.Repo}}\n  digest: {{.Digest}}", true},
+		{"{{if .Digest}}image: {{.Repo}}@{{.Digest}}{{else}}image: {{.Repo}}:{{.Tag}}{{end}}", true},
+		{"{{if .Digest}}# pinned{{end}}\nimage: {{.Repo}}:{{.Tag}}", false},
+		{"{{with .Digest}}{{end}}image: {{.Repo}}:{{.Tag}}", false},
+		{"{{if false}}{{.Image}}{{end}}image: {{.Repo}}:{{.Tag}}", false},
+		{"{{define \"img\"}}{{.Image}}{{end}}image: {{template \"img\" .}}", true},
+		{"image: {{printf \"%v:%v\" .Repo .Tag}}", false},
+		{"# {{/* .Image */}}\nimage: {{.Repo}}", false},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		tpl := filepath.Join(dir, "values.tpl")
+		writeTestFile(t, tpl, tt.text)
+		out, err := os.Create(filepath.Join(dir, "values.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = renderHelmValuesFile(New(), out, tpl, helmValues{Repo: "registry.example.com/team/app", Tag: "v1", Digest: testDigest1})
+		out.Close()
+		rendered, _ := ioutil.ReadFile(out.Name())
+		switch {
+		case tt.ok && err != nil:
+			t.Errorf("%q: %v", tt.text, err)
+		case !tt.ok && (err == nil || !strings.Contains(err.Error(), "unpinned")):
+			t.Errorf("%q: rendered unpinned values %q, %v", tt.text, rendered, err)
+		case !tt.ok && len(rendered) > 0:
+			t.Errorf("%q: wrote unpinned values %q", tt.text, rendered)
+		}
+	}
+}
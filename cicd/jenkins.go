@@ -0,0 +1,42 @@
+package cicd
+
+import (
+	"log"
+	"os"
+)
+
+type Jenkins struct {
+	Name string
+}
+
+// BuildInfo reads the Jenkins multibranch pipeline environment.  Change requests (CHANGE_ID) are
+// reported as a pull_request of CHANGE_BRANCH, tag builds (TAG_NAME) as the tag event and other builds
+// as pushes of BRANCH_NAME.  GIT_COMMIT is set by the git plugin when the job checks out scm
+func (j *Jenkins) BuildInfo() (bi BuildInfo, err error) {
+	if os.Getenv("JENKINS_URL") == "" {
+		log.Println("jenkins: not running under jenkins; using flags")
+		return bi, err
+	}
+
+	bi.Branch = os.Getenv("BRANCH_NAME")
+	bi.Commit = os.Getenv("GIT_COMMIT")
+	bi.Build = os.Getenv("BUILD_NUMBER")
+	bi.Tag = os.Getenv("TAG_NAME")
+
+	switch {
+	case os.Getenv("CHANGE_ID") != "":
+		bi.Event = "pull_request"
+		bi.PR = os.Getenv("CHANGE_ID")
+
+		// BRANCH_NAME of a change request is PR-<n>; use the branch being merged
+		if b := os.Getenv("CHANGE_BRANCH"); b != "" {
+			bi.Branch = b
+		}
+	case bi.Tag != "":
+		bi.Event = "tag"
+		bi.Branch = ""
+	default:
+		bi.Event = "push"
+	}
+	return bi, err
+}
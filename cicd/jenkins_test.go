@@ -0,0 +1,45 @@
+package cicd
+
+import (
+	"testing"
+)
+
+func TestJenkinsBuildInfo(t *testing.T) {
+	keys := []string{"JENKINS_URL", "BRANCH_NAME", "GIT_COMMIT", "BUILD_NUMBER", "TAG_NAME", "CHANGE_ID", "CHANGE_BRANCH"}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want BuildInfo
+	}{
+		{"not jenkins", map[string]string{"BRANCH_NAME": "main"}, BuildInfo{}},
+		{"branch", map[string]string{"BRANCH_NAME": "main"},
+			BuildInfo{Branch: "main", Event: "push"}},
+		{"change request", map[string]string{"BRANCH_NAME": "PR-7", "CHANGE_ID": "7", "CHANGE_BRANCH": "feature/x"},
+			BuildInfo{Branch: "feature/x", Event: "pull_request", PR: "7"}},
+		{"change request without branch", map[string]string{"BRANCH_NAME": "PR-7", "CHANGE_ID": "7"},
+			BuildInfo{Branch: "PR-7", Event: "pull_request", PR: "7"}},
+		{"tag", map[string]string{"BRANCH_NAME": "v1.2.0", "TAG_NAME": "v1.2.0"},
+			BuildInfo{Event: "tag", Tag: "v1.2.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want != (BuildInfo{}) {
+				tt.env["JENKINS_URL"] = "https://jenkins.example.com/"
+				tt.env["GIT_COMMIT"] = "abc123"
+				tt.env["BUILD_NUMBER"] = "42"
+				want.Commit, want.Build = "abc123", "42"
+			}
+			setTestEnv(t, keys, tt.env)
+
+			bi, err := (&Jenkins{}).BuildInfo()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bi != want {
+				t.Errorf("BuildInfo = %+v, want %+v", bi, want)
+			}
+		})
+	}
+}
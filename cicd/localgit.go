@@ -0,0 +1,64 @@
+package cicd
+
+import (
+	"log"
+	"strings"
+)
+
+// LocalGit derives the build context from the local git repository, for developer runs outside CI.
+// It is used when no CI provider is configured or the configured one detects no CI environment.  Branch,
+// commit and tag are read from .git directly; only the dirty check asks the git binary, so that eol
+// conversion and clean filters apply as they do for `git status`
+type LocalGit struct {
+	Dir string
+
+	executor Executor
+}
+
+func (l *LocalGit) SetExecutor(e Executor) {
+	l.executor = e
+}
+
+// BuildInfo reports a push of the checked out branch and commit, with the tag pointing at the commit as
+// the version.  Outside a git repository nothing is detected and flags must be given
+func (l *LocalGit) BuildInfo() (bi BuildInfo, err error) {
+	dir := l.Dir
+	if dir == "" {
+		dir = "."
+	}
+
+	g, gerr := openGitRepo(dir)
+	if gerr != nil {
+		log.Println("git:", gerr, "; using flags")
+		return bi, err
+	}
+
+	// a detached head has no branch and an unborn branch no commit
+	if bi.Branch, bi.Commit, err = g.head(); err != nil {
+		return bi, err
+	}
+	bi.Event = "push"
+
+	if bi.Commit != "" {
+		if bi.Tag, err = g.tagAt(bi.Commit); err != nil {
+			return bi, err
+		}
+	}
+
+	// untracked files are ignored, as with `git describe --dirty`
+	var status string
+	if status, err = l.git("--no-optional-locks", "status", "--porcelain", "--untracked-files=no"); err != nil {
+		return bi, err
+	}
+	bi.Dirty = status != ""
+	return bi, err
+}
+
+// git runs a read-only git command in Dir, in dryrun mode too, returning its trimmed output
+func (l *LocalGit) git(args ...string) (string, error) {
+	if l.Dir != "" {
+		args = append([]string{"-C", l.Dir}, args...)
+	}
+	res, err := run(l.executor, Command{Name: "git", Args: args, Always: true, Quiet: true})
+	return strings.TrimSpace(string(res.Stdout)), err
+}
@@ -0,0 +1,199 @@
+package cicd
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func gitRecording(dir string, stdout string, args ...string) Recording {
+	return Recording{Command: Command{Name: "git", Args: append([]string{"-C", dir}, args...), Always: true, Quiet: true}, Stdout: stdout}
+}
+
+// writeGitObject stores a loose object in the repository at gitDir, returning its id
+func writeGitObject(t *testing.T, gitDir string, typ string, content string) (sha string) {
+	t.Helper()
+	raw := fmt.Sprintf("%s %d\x00%s", typ, len(content), content)
+	sha = fmt.Sprintf("%x", sha1.Sum([]byte(raw)))
+
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	zw.Write([]byte(raw))
+	zw.Close()
+	writeTestFile(t, filepath.Join(gitDir, "objects", sha[:2], sha[2:]), b.String())
+	return sha
+}
+
+func TestLocalGitBuildInfo(t *testing.T) {
+	const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	objects := func(gitDir string) (commit, other, tag string) {
+		commit = writeGitObject(t, gitDir, "commit", "tree "+emptyTree+"\n\nsecond\n")
+		other = writeGitObject(t, gitDir, "commit", "tree "+emptyTree+"\n\nfirst\n")
+		tag = writeGitObject(t, gitDir, "tag", "object "+commit+"\ntype commit\ntag v1.2.5\n\nrelease\n")
+		return commit, other, tag
+	}
+	commit, other, tagObj := objects(t.TempDir())
+	status := []string{"--no-optional-locks", "status", "--porcelain", "--untracked-files=no"}
+
+	tests := []struct {
+		name   string
+		common string
+		files  map[string]string
+		dirty  bool
+		want   BuildInfo
+	}{
+		{"loose branch and tag", ".git", map[string]string{
+			".git/HEAD":                  "ref: refs/heads/feature/x\n",
+			".git/refs/heads/feature/x":  commit + "\n",
+			".git/refs/tags/v1.2.0":      commit + "\n",
+			".git/refs/tags/v1.1.0":      other + "\n",
+			".git/refs/tags/v1.2.5-rc.1": commit + "\n",
+			".git/refs/tags/v1.2.5":      tagObj + "\n",
+		}, false, BuildInfo{Branch: "feature/x", Event: "push", Commit: commit, Tag: "v1.2.5"}},
+		{"packed refs with a peeled tag", ".git", map[string]string{
+			".git/HEAD":        "ref: refs/heads/main\n",
+			".git/packed-refs": "# pack-refs with: peeled fully-peeled sorted\n" + commit + " refs/heads/main\n" + tagObj + " refs/tags/v2.0.0\n^" + commit + "\n" + other + " refs/tags/v1.0.0\n",
+		}, false, BuildInfo{Branch: "main", Event: "push", Commit: commit, Tag: "v2.0.0"}},
+		{"detached and dirty after a tag", ".git", map[string]string{
+			".git/HEAD":             commit + "\n",
+			".git/refs/tags/v1.0.0": other + "\n",
+		}, true, BuildInfo{Event: "push", Commit: commit, Dirty: true}},
+		{"unborn branch", ".git", map[string]string{
+			".git/HEAD": "ref: refs/heads/main\n",
+		}, false, BuildInfo{Branch: "main", Event: "push"}},
+		{"linked worktree", "main/.git", map[string]string{
+			"main/.git/refs/heads/main":         other + "\n",
+			"main/.git/refs/heads/feature/y":    commit + "\n",
+			"main/.git/refs/tags/v3.0.0":        commit + "\n",
+			"main/.git/worktrees/app/HEAD":      "ref: refs/heads/feature/y\n",
+			"main/.git/worktrees/app/commondir": "../..\n",
+			".git":                              "gitdir: main/.git/worktrees/app\n",
+		}, false, BuildInfo{Branch: "feature/y", Event: "push", Commit: commit, Tag: "v3.0.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			objects(filepath.Join(dir, tt.common))
+			for name, content := range tt.files {
+				writeTestFile(t, filepath.Join(dir, name), content)
+			}
+			out := ""
+			if tt.dirty {
+				out = " M main.go\n"
+			}
+			e := NewReplayExecutor([]Recording{gitRecording(dir, out, status...)})
+			l := &LocalGit{Dir: dir}
+			l.SetExecutor(e)
+
+			bi, err := l.BuildInfo()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bi != tt.want {
+				t.Errorf("BuildInfo = %+v, want %+v", bi, tt.want)
+			}
+			if remaining := e.Remaining(); len(remaining) > 0 {
+				t.Errorf("commands not run: %v", remaining)
+			}
+		})
+	}
+
+	// outside a repository nothing is detected and git is not run
+	e := NewReplayExecutor(nil)
+	l := &LocalGit{Dir: t.TempDir()}
+	l.SetExecutor(e)
+	if bi, err := l.BuildInfo(); err != nil || bi != (BuildInfo{}) {
+		t.Errorf("outside repository: BuildInfo = %+v, %v", bi, err)
+	}
+}
+
+// gitFixture runs git in dir, failing the test on error
+func gitFixture(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_CONFIG_NOSYSTEM=1", "HOME="+dir,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func TestLocalGitRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	gitFixture(t, dir, "init", "-q")
+	gitFixture(t, dir, "checkout", "-q", "-b", "main")
+	gitFixture(t, dir, "config", "core.autocrlf", "true")
+
+	// a CRLF file is stored with LF endings; touching it must not make the tree dirty
+	writeTestFile(t, filepath.Join(dir, "windows.txt"), "one\r\ntwo\r\n")
+	gitFixture(t, dir, "add", ".")
+	gitFixture(t, dir, "commit", "-q", "-m", "first")
+	gitFixture(t, dir, "tag", "v0.9.0")
+
+	// annotated tags with long similar messages are packed as deltas of each other
+	gitFixture(t, dir, "commit", "-q", "--allow-empty", "-m", "second")
+	notes := strings.Repeat("release notes\n", 200)
+	for _, v := range []string{"v1.0.0-rc.1", "v1.0.0", "v0.10.0"} {
+		gitFixture(t, dir, "tag", "-a", v, "-m", notes+v)
+	}
+
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "windows.txt"), future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	l := &LocalGit{Dir: dir}
+	check := func(name string, want BuildInfo) {
+		t.Helper()
+		bi, err := l.BuildInfo()
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		want.Event = "push"
+		want.Commit = bi.Commit
+		if len(bi.Commit) != 40 || bi != want {
+			t.Errorf("%v: BuildInfo = %+v, want %+v", name, bi, want)
+		}
+	}
+
+	// loose objects and refs, then packed objects with offset and reference deltas, then packed refs
+	check("loose", BuildInfo{Branch: "main", Tag: "v1.0.0"})
+	gitFixture(t, dir, "repack", "-q", "-a", "-d", "-f", "--window=250", "--depth=50")
+	check("packed objects", BuildInfo{Branch: "main", Tag: "v1.0.0"})
+	gitFixture(t, dir, "-c", "repack.useDeltaBaseOffset=false", "repack", "-q", "-a", "-d", "-f", "--window=250", "--depth=50")
+	check("reference deltas", BuildInfo{Branch: "main", Tag: "v1.0.0"})
+	gitFixture(t, dir, "pack-refs", "--all")
+	check("packed refs", BuildInfo{Branch: "main", Tag: "v1.0.0"})
+
+	// the version is only taken from a tag on the commit itself
+	gitFixture(t, dir, "commit", "-q", "--allow-empty", "-m", "third")
+	check("untagged commit", BuildInfo{Branch: "main"})
+	gitFixture(t, dir, "checkout", "-q", "--detach", "v0.9.0")
+	check("detached", BuildInfo{Tag: "v0.9.0"})
+	gitFixture(t, dir, "checkout", "-q", "main")
+
+	writeTestFile(t, filepath.Join(dir, "windows.txt"), "changed\r\n")
+	writeTestFile(t, filepath.Join(dir, "untracked.txt"), "ignored")
+	check("modified tree", BuildInfo{Branch: "main", Dirty: true})
+
+	// a linked worktree reports its own branch
+	worktree := filepath.Join(t.TempDir(), "wt")
+	gitFixture(t, dir, "worktree", "add", "-q", "-b", "feature/x", worktree, "v1.0.0")
+	l = &LocalGit{Dir: worktree}
+	check("worktree", BuildInfo{Branch: "feature/x", Tag: "v1.0.0"})
+}
@@ -0,0 +1,148 @@
+package cicd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// platformImage is a per-platform image given as <os>/<arch>[/<variant>]=<image>.  For registries
+// pushing from a local source the image is an OCI layout or tarball path
+type platformImage struct {
+	Platform Platform
+	Image    string
+}
+
+func (p platformImage) platformString() string {
+	s := p.Platform.OS + "/" + p.Platform.Architecture
+	if p.Platform.Variant != "" {
+		s += "/" + p.Platform.Variant
+	}
+	return s
+}
+
+// parsePlatformImages parses the --platform-image options
+func parsePlatformImages(specs []string) (images []platformImage, err error) {
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("platform image %q must be <os>/<arch>[/<variant>]=<image>", spec)
+		}
+
+		parts := strings.Split(kv[0], "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("platform %q must be <os>/<arch>[/<variant>]", kv[0])
+		}
+		pi := platformImage{Platform: Platform{OS: parts[0], Architecture: parts[1]}, Image: kv[1]}
+		if len(parts) == 3 {
+			pi.Platform.Variant = parts[2]
+		}
+
+		if seen[pi.platformString()] {
+			return nil, fmt.Errorf("platform %v given more than once", pi.platformString())
+		}
+		seen[pi.platformString()] = true
+		images = append(images, pi)
+	}
+	return images, err
+}
+
+// pushPlatforms publishes a multi-architecture image: each platform image is pushed under its own
+// <commit tag>-<os>-<arch> tag, every platform manifest is verified in the registry, then an image
+// index listing them is pushed under each of images
+func (wf *Workflow) pushPlatforms(ar Registrator, images []string, opts Options) (result PushResult, err error) {
+	platforms, err := parsePlatformImages(opts.Platforms)
+	if err != nil {
+		return result, err
+	}
+
+	repoURL := ar.GetRepoURL()
+	repo, err := ParseRepository(repoURL)
+	if err != nil {
+		return result, err
+	}
+	base, err := ParseReference(opts.Image)
+	if err != nil {
+		return result, fmt.Errorf("--image: %v", err)
+	}
+
+	// push the platform images one at a time; a source pusher reads one source per push
+	var platformRefs []string
+	for _, p := range platforms {
+		ref := repo.WithTag(SanitizeTag(base.Tag + "-" + strings.Replace(p.platformString(), "/", "-", -1))).String()
+		platformRefs = append(platformRefs, ref)
+
+		if sp, ok := ar.(SourcePusher); ok {
+			sp.SetSource(p.Image)
+		} else if _, err = wf.Executor().Execute(Command{Name: "docker", Args: []string{"tag", p.Image, ref}}); err != nil {
+			return result, err
+		}
+
+		var pr PushResult
+		if pr, err = ar.Push([]string{ref}); err != nil {
+			return result, fmt.Errorf("platform %v: %v", p.platformString(), err)
+		}
+		result.Pushed = append(result.Pushed, pr.Pushed...)
+		result.Unchanged = append(result.Unchanged, pr.Unchanged...)
+	}
+
+	if wf.IsDryRun() {
+		log.Println("dryrun: push image index of", platformRefs, "as", images)
+		return result, err
+	}
+
+	api, ok := ar.(RegistryAPI)
+	if !ok || api.Client() == nil {
+		return result, fmt.Errorf("%v: registry api unavailable to push an image index", repoURL)
+	}
+	c := api.Client()
+	_, apiRepo := splitRepoURL(repoURL)
+
+	// verify every platform manifest before publishing the index
+	index := Index{SchemaVersion: 2, MediaType: MediaTypeDockerManifestList}
+	for i, p := range platforms {
+		var mediaType string
+		var body []byte
+		if mediaType, body, err = c.GetManifest(apiRepo, tagOf(repoURL, platformRefs[i])); err != nil {
+			return result, fmt.Errorf("platform %v: %v", p.platformString(), err)
+		}
+		switch {
+		case body == nil:
+			return result, fmt.Errorf("platform %v: manifest %v not found in registry", p.platformString(), platformRefs[i])
+		case mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerManifestList:
+			return result, fmt.Errorf("platform %v: %v is already an image index", p.platformString(), platformRefs[i])
+		case mediaType != MediaTypeDockerManifest:
+			index.MediaType = MediaTypeOCIIndex
+		}
+
+		platform := p.Platform
+		index.Manifests = append(index.Manifests, Descriptor{MediaType: mediaType, Digest: digestOf(body), Size: int64(len(body)), Platform: &platform})
+	}
+
+	body, err := json.Marshal(index)
+	if err != nil {
+		return result, err
+	}
+	indexDigest := digestOf(body)
+
+	var tagged PushResult
+	tagged, err = pushEach(images, wf.Config.Provider.Registry.Concurrency, func(image string) (pi PushedImage, unchanged bool, err error) {
+		tag := tagOf(repoURL, image)
+		pi = PushedImage{Digest: indexDigest, Size: int64(len(body))}
+
+		var remote string
+		if remote, err = c.ManifestDigest(apiRepo, tag); err != nil || remote == indexDigest {
+			return pi, err == nil, err
+		}
+		if pi.Digest, err = c.PutManifest(apiRepo, tag, index.MediaType, body); err != nil {
+			return pi, false, err
+		}
+		log.Println("pushed image index:", image, pi.Digest)
+		return pi, false, err
+	})
+	result.Pushed = append(result.Pushed, tagged.Pushed...)
+	result.Unchanged = append(result.Unchanged, tagged.Unchanged...)
+	return result, err
+}
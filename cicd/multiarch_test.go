@@ -0,0 +1,118 @@
+package cicd
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePlatformImages(t *testing.T) {
+	tests := []struct {
+		specs []string
+		want  []platformImage
+		err   string
+	}{
+		{[]string{"linux/amd64=app:amd64", "linux/arm64/v8=app:arm64"}, []platformImage{
+			{Platform{OS: "linux", Architecture: "amd64"}, "app:amd64"},
+			{Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, "app:arm64"},
+		}, ""},
+		{[]string{"linux/amd64"}, nil, "must be <os>/<arch>[/<variant>]=<image>"},
+		{[]string{"linux/amd64="}, nil, "must be <os>/<arch>[/<variant>]=<image>"},
+		{[]string{"linux=app"}, nil, `platform "linux" must be`},
+		{[]string{"linux/arm/v7/x=app"}, nil, "must be <os>/<arch>[/<variant>]"},
+		{[]string{"linux/amd64=a", "linux/amd64=b"}, nil, "given more than once"},
+	}
+	for _, tt := range tests {
+		got, err := parsePlatformImages(tt.specs)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%v: error = %v, want %q", tt.specs, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || len(got) != len(tt.want) {
+			t.Fatalf("%v: %+v, %v", tt.specs, got, err)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%v: %+v, want %+v", tt.specs, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestPushPlatforms(t *testing.T) {
+	reg := newTestRegistry(t)
+	dir := t.TempDir()
+	amd64 := writeTestLayout(t, filepath.Join(dir, "amd64"), "amd64 layer")
+	arm64 := writeTestLayout(t, filepath.Join(dir, "arm64"), "arm64 layer")
+
+	wf := New()
+	wf.Provider.CI.LocalGit.Dir = dir // not a git repository
+	wf.Config.Provider.Registry.ID = "oci"
+	wf.Provider.Registry.OCI.Url = reg.Host() + "/team/app"
+	wf.Provider.Registry.OCI.Insecure = true
+	wf.Options = Options{Image: "app:abc123", Event: "push", Branch: "master",
+		Platforms: []string{"linux/amd64=" + filepath.Join(dir, "amd64"), "linux/arm64/v8=" + filepath.Join(dir, "arm64")}}
+
+	results, err := wf.Push()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the platform images under their own tags, then the index under each tag
+	if got := reg.Manifest("team/app", "abc123-linux-amd64"); got == nil || digestOf(got) != amd64 {
+		t.Errorf("amd64 platform tag = %s", got)
+	}
+	if got := reg.Manifest("team/app", "abc123-linux-arm64-v8"); got == nil || digestOf(got) != arm64 {
+		t.Errorf("arm64 platform tag = %s", got)
+	}
+	var index Index
+	if err = json.Unmarshal(reg.Manifest("team/app", "master"), &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.MediaType != MediaTypeOCIIndex || len(index.Manifests) != 2 ||
+		index.Manifests[0].Digest != amd64 || index.Manifests[0].Platform.Architecture != "amd64" ||
+		index.Manifests[1].Digest != arm64 || index.Manifests[1].Platform.Variant != "v8" {
+		t.Errorf("index = %+v", index)
+	}
+	for _, tag := range []string{"abc123", "latest"} {
+		if got := reg.Manifest("team/app", tag); got == nil || digestOf(got) != results[0].Pushed[4].Digest {
+			t.Errorf("index tag %v = %s", tag, got)
+		}
+	}
+	if len(results[0].Pushed) != 5 {
+		t.Errorf("pushed %+v", results[0].Pushed)
+	}
+
+	// a repeat push leaves everything unchanged
+	if results, err = wf.Push(); err != nil || len(results[0].Unchanged) != 5 || len(results[0].Pushed) != 0 {
+		t.Errorf("repeat push %+v, %v", results, err)
+	}
+}
+
+func TestPushPlatformsMissingManifest(t *testing.T) {
+	reg := newTestRegistry(t)
+	dir := t.TempDir()
+	writeTestLayout(t, filepath.Join(dir, "amd64"), "amd64 layer")
+
+	wf := New()
+	wf.Provider.CI.LocalGit.Dir = dir // not a git repository
+	wf.Config.Provider.Registry.ID = "oci"
+	wf.Provider.Registry.OCI.Url = reg.Host() + "/team/app"
+	wf.Provider.Registry.OCI.Insecure = true
+	wf.Options = Options{Image: "app:abc123", Event: "push", Branch: "master",
+		Platforms: []string{"linux/amd64=" + filepath.Join(dir, "amd64")}}
+
+	// the platform manifest lookup fails, so no index is published
+	reg.FailNext("/manifests/abc123-linux-amd64", 2)
+	if _, err := wf.Push(); err == nil || !strings.Contains(err.Error(), "platform linux/amd64") {
+		t.Fatalf("Push error = %v", err)
+	}
+	for _, tag := range []string{"abc123", "master", "latest"} {
+		if reg.Manifest("team/app", tag) != nil {
+			t.Errorf("index published under %v without a verified platform manifest", tag)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package cicd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// OCI pushes images from a local OCI layout or tarball straight to a registry over the distribution
+// HTTP API, without a docker daemon or registry CLI
+type OCI struct {
+	Name        string
+	Description string
+	Host        string
+	Repo        string
+	Url         string
+	Insecure    bool
+	Credentials Credentials
+
+	registryRuntime
+
+	source string
+	dryrun bool
+}
+
+func (r *OCI) SetSource(path string) {
+	r.source = path
+}
+
+func (r *OCI) SetDryRun(dryrun bool) {
+	r.dryrun = dryrun
+}
+
+func (r *OCI) GetRepoURL() (repoURL string) {
+	return r.Url
+}
+
+func (r *OCI) ListTags() ([]string, error) {
+	return listTags(r.client, r.Url)
+}
+
+func (r *OCI) IsRegistryValid() (err error) {
+	if r.Url == "" {
+		err = fmt.Errorf("registry url missing from %v configuration", r.Description)
+	} else if ref, perr := ParseRepository(r.Url); perr != nil {
+		err = fmt.Errorf("%v configuration: %v", r.Description, perr)
+	} else if ref.Registry() == "" {
+		err = fmt.Errorf("registry url must be <host>/<repository> in %v configuration: %v", r.Description, r.Url)
+	}
+	return err
+}
+
+// Authenticate verifies push access with credentials from the declared source.  Without one it uses a
+// static bearer token from REGISTRY_TOKEN, else basic credentials from REGISTRY_USER/REGISTRY_PASSWORD,
+// otherwise anonymous access
+func (r *OCI) Authenticate() (err error) {
+	host, repo := splitRepoURL(r.Url)
+
+	var user, pass string
+	if r.Credentials.Source == "" {
+		user, pass = os.Getenv("REGISTRY_USER"), os.Getenv("REGISTRY_PASSWORD")
+	} else if user, pass, _, err = r.Credentials.Resolve(r.executor, host, "REGISTRY_USER", "REGISTRY_PASSWORD"); err != nil {
+		return err
+	}
+	r.newClient(host, user, pass)
+	r.client.Insecure = r.Insecure
+	if r.Credentials.Source == "" {
+		r.client.Token = os.Getenv("REGISTRY_TOKEN")
+	}
+
+	if r.dryrun {
+		log.Println("dryrun: GET", r.client.url("/v2/"), "scope", repoScope(repo, "pull,push"))
+		return err
+	}
+	return r.client.Ping(repo)
+}
+
+func (r *OCI) Push(images []string) (result PushResult, err error) {
+	if r.client == nil {
+		return result, fmt.Errorf("%v: push before authenticate", r.Description)
+	}
+	_, repo := splitRepoURL(r.Url)
+
+	li, err := OpenLocalImage(r.source)
+	if err != nil {
+		return result, err
+	}
+	defer li.Close()
+
+	for _, image := range images {
+		if tagOf(r.Url, image) == "" {
+			return result, fmt.Errorf("%v: image not in repository %v", image, r.Url)
+		}
+	}
+
+	// upload config and layer blobs once, before any manifest references them
+	var uploadOnce sync.Once
+	var uploadErr error
+	upload := func() error {
+		uploadOnce.Do(func() {
+			_, uploadErr = pushEach(blobDigests(li.Blobs), r.concurrency, func(digest string) (pi PushedImage, unchanged bool, err error) {
+				return pi, false, r.uploadBlob(repo, li, blobByDigest(li.Blobs, digest))
+			})
+		})
+		return uploadErr
+	}
+
+	return pushEach(images, r.concurrency, func(image string) (pi PushedImage, unchanged bool, err error) {
+		tag := tagOf(r.Url, image)
+		pi = PushedImage{Digest: li.Digest(), Size: int64(len(li.Manifest))}
+
+		// skip tags already pointing at this manifest
+		if !r.dryrun {
+			var remote string
+			if remote, err = r.client.ManifestDigest(repo, tag); err != nil || remote == pi.Digest {
+				return pi, err == nil, err
+			}
+		}
+
+		if err = upload(); err != nil {
+			return pi, false, err
+		}
+
+		if r.dryrun {
+			log.Println("dryrun: PUT", r.client.url("/v2/%s/manifests/%s", repo, tag))
+			return pi, false, err
+		}
+		if pi.Digest, err = r.client.PutManifest(repo, tag, li.MediaType, li.Manifest); err != nil {
+			return pi, false, err
+		}
+		log.Println("pushed manifest:", image, pi.Digest)
+		return pi, false, err
+	})
+}
+
+func (r *OCI) uploadBlob(repo string, li *LocalImage, d Descriptor) (err error) {
+	if r.dryrun {
+		log.Println("dryrun: PUT", r.client.url("/v2/%s/blobs/%s", repo, d.Digest))
+		return err
+	}
+
+	var exists bool
+	if exists, err = r.client.BlobExists(repo, d); err != nil || exists {
+		return err
+	}
+
+	log.Println("uploading blob:", d.Digest, d.Size, "bytes")
+	return r.client.UploadBlob(repo, d, func() (io.ReadCloser, error) {
+		return li.Open(d)
+	})
+}
+
+func blobDigests(blobs []Descriptor) (digests []string) {
+	for _, d := range blobs {
+		digests = append(digests, d.Digest)
+	}
+	return digests
+}
+
+func blobByDigest(blobs []Descriptor, digest string) Descriptor {
+	for _, d := range blobs {
+		if d.Digest == digest {
+			return d
+		}
+	}
+	return Descriptor{Digest: digest}
+}
+
+// splitRepoURL separates a repository url into distribution API host and repository path, normalizing
+// Docker Hub names.  Malformed urls are rejected earlier by IsRegistryValid
+func splitRepoURL(repoURL string) (host string, repo string) {
+	ref, err := ParseReference(repoURL)
+	if err != nil {
+		return host, repo
+	}
+	return ref.APIHost(), ref.APIPath()
+}
@@ -0,0 +1,65 @@
+package cicd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOCIPush(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.Token = "secret-token"
+
+	source := filepath.Join(t.TempDir(), "layout")
+	digest := writeTestLayout(t, source, "layer data")
+
+	r := &OCI{Url: reg.Host() + "/team/app", Insecure: true}
+	r.SetSource(source)
+	r.SetConcurrency(2)
+	if err := r.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+
+	images := []string{reg.Host() + "/team/app:abc123", reg.Host() + "/team/app:master"}
+	result, err := r.Push(images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Pushed) != 2 || result.Pushed[0].Digest != digest || reg.uploads != 2 {
+		t.Fatalf("pushed %+v with %d blob uploads", result, reg.uploads)
+	}
+	if reg.Manifest("team/app", "master") == nil {
+		t.Fatal("manifest not stored under tag master")
+	}
+
+	// a second push of the same digest uploads nothing
+	result, err = r.Push(images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Unchanged) != 2 || len(result.Pushed) != 0 || reg.uploads != 2 {
+		t.Errorf("repeat push %+v with %d blob uploads", result, reg.uploads)
+	}
+}
+
+func TestOCIAuthenticateToken(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.Token = "secret-token"
+	r := &OCI{Url: reg.Host() + "/team/app", Insecure: true}
+
+	t.Setenv("REGISTRY_TOKEN", "wrong-token")
+	if err := r.Authenticate(); err == nil {
+		t.Fatal("authenticated with a rejected static token")
+	}
+
+	// a static token is presented as is, without a token exchange
+	t.Setenv("REGISTRY_TOKEN", "secret-token")
+	if err := r.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range reg.Requests() {
+		if strings.Contains(req, "/token") {
+			t.Errorf("static token exchanged: %v", req)
+		}
+	}
+}
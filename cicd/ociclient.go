@@ -0,0 +1,523 @@
+package cicd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// RegistryClient speaks the OCI distribution HTTP API to a single registry host
+type RegistryClient struct {
+	Host     string
+	Insecure bool
+
+	// basic credentials, also presented to the token service on a bearer challenge
+	Username string
+	Password string
+
+	// static bearer token; when set no token exchange is attempted
+	Token string
+
+	HTTPClient *http.Client
+
+	// Retry re-sends requests failing with network errors, 429 or 5xx responses
+	Retry Retry
+
+	mu     sync.Mutex
+	basic  bool
+	tokens map[string]string
+}
+
+func NewRegistryClient(host string) *RegistryClient {
+	return &RegistryClient{Host: host, HTTPClient: http.DefaultClient, tokens: map[string]string{}}
+}
+
+func (c *RegistryClient) url(format string, a ...interface{}) string {
+	scheme := "https"
+	if c.Insecure {
+		scheme = "http"
+	}
+	return scheme + "://" + c.Host + fmt.Sprintf(format, a...)
+}
+
+var manifestAccept = strings.Join([]string{
+	MediaTypeOCIManifest,
+	MediaTypeOCIIndex,
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+}, ", ")
+
+func repoScope(repo string, actions string) string {
+	return "repository:" + repo + ":" + actions
+}
+
+// Ping checks the registry answers the distribution API and that credentials grant push on repo
+func (c *RegistryClient) Ping(repo string) (err error) {
+	resp, err := c.do(repoScope(repo, "pull,push"), func() (*http.Request, error) {
+		return http.NewRequest("GET", c.url("/v2/"), nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return err
+}
+
+// BlobExists reports whether repo already holds the blob described by d
+func (c *RegistryClient) BlobExists(repo string, d Descriptor) (bool, error) {
+	resp, err := c.do(repoScope(repo, "pull,push"), func() (*http.Request, error) {
+		return http.NewRequest("HEAD", c.url("/v2/%s/blobs/%s", repo, d.Digest), nil)
+	})
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, responseError(resp)
+}
+
+// GetBlob opens the blob with digest in repo, following redirects to the registry's storage backend
+func (c *RegistryClient) GetBlob(repo string, digest string) (io.ReadCloser, error) {
+	resp, err := c.do(repoScope(repo, "pull"), func() (*http.Request, error) {
+		return http.NewRequest("GET", c.url("/v2/%s/blobs/%s", repo, digest), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp.Body, nil
+}
+
+// UploadBlob uploads the blob described by d in a single monolithic PUT
+func (c *RegistryClient) UploadBlob(repo string, d Descriptor, open func() (io.ReadCloser, error)) (err error) {
+	scope := repoScope(repo, "pull,push")
+
+	// start upload session
+	resp, err := c.do(scope, func() (*http.Request, error) {
+		return http.NewRequest("POST", c.url("/v2/%s/blobs/uploads/", repo), nil)
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return responseError(resp)
+	}
+
+	location, err := c.resolve(resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+	q := location.Query()
+	q.Set("digest", d.Digest)
+	location.RawQuery = q.Encode()
+
+	// complete upload with blob content
+	resp, err = c.do(scope, func() (*http.Request, error) {
+		body, err := open()
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest("PUT", location.String(), body)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		req.ContentLength = d.Size
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp)
+	}
+	return err
+}
+
+// PutManifest stores manifest under ref (tag or digest) and returns the digest reported by the registry
+func (c *RegistryClient) PutManifest(repo string, ref string, mediaType string, manifest []byte) (digest string, err error) {
+	resp, err := c.do(repoScope(repo, "pull,push"), func() (*http.Request, error) {
+		req, err := http.NewRequest("PUT", c.url("/v2/%s/manifests/%s", repo, ref), bytes.NewReader(manifest))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mediaType)
+		return req, nil
+	})
+	if err != nil {
+		return digest, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return digest, responseError(resp)
+	}
+
+	if digest = resp.Header.Get("Docker-Content-Digest"); digest == "" {
+		digest = digestOf(manifest)
+	}
+	return digest, err
+}
+
+// ManifestDigest returns the digest of the manifest at ref, or "" when ref does not exist
+func (c *RegistryClient) ManifestDigest(repo string, ref string) (digest string, err error) {
+	resp, err := c.do(repoScope(repo, "pull"), func() (*http.Request, error) {
+		req, err := http.NewRequest("HEAD", c.url("/v2/%s/manifests/%s", repo, ref), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", manifestAccept)
+		return req, nil
+	})
+	if err != nil {
+		return digest, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if digest = resp.Header.Get("Docker-Content-Digest"); digestRE.MatchString(digest) {
+			return digest, nil
+		}
+	case http.StatusNotFound:
+		return digest, nil
+	default:
+		return digest, responseError(resp)
+	}
+
+	// the header is optional, so without one the digest is that of the manifest itself
+	_, manifest, err := c.GetManifest(repo, ref)
+	if err != nil || manifest == nil {
+		return "", err
+	}
+	return digestOf(manifest), nil
+}
+
+// GetManifest fetches the manifest at ref, returning a nil body when ref does not exist
+func (c *RegistryClient) GetManifest(repo string, ref string) (mediaType string, manifest []byte, err error) {
+	resp, err := c.do(repoScope(repo, "pull"), func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", c.url("/v2/%s/manifests/%s", repo, ref), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", manifestAccept)
+		return req, nil
+	})
+	if err != nil {
+		return mediaType, manifest, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		manifest, err = ioutil.ReadAll(resp.Body)
+		return resp.Header.Get("Content-Type"), manifest, err
+	case http.StatusNotFound:
+		return mediaType, manifest, err
+	}
+	return mediaType, manifest, responseError(resp)
+}
+
+// Tags lists the tags of repo, following pagination links.  A repository that does not exist yet has no tags
+func (c *RegistryClient) Tags(repo string) (tags []string, err error) {
+	next := c.url("/v2/%s/tags/list", repo)
+	for next != "" {
+		page := next
+		var resp *http.Response
+		resp, err = c.do(repoScope(repo, "pull"), func() (*http.Request, error) {
+			return http.NewRequest("GET", page, nil)
+		})
+		if err != nil {
+			return tags, err
+		}
+
+		var list struct {
+			Tags []string
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = json.NewDecoder(resp.Body).Decode(&list)
+		case http.StatusNotFound:
+			resp.Body.Close()
+			return tags, nil
+		default:
+			err = responseError(resp)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return tags, err
+		}
+		tags = append(tags, list.Tags...)
+
+		if next, err = c.nextLink(resp.Header.Get("Link")); err != nil {
+			return tags, err
+		}
+	}
+	return tags, err
+}
+
+// nextLink returns the absolute url of a `<url>; rel="next"` Link header, or "" without one
+func (c *RegistryClient) nextLink(link string) (string, error) {
+	if link == "" || !strings.Contains(link, `rel="next"`) {
+		return "", nil
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("registry %v: malformed Link header %q", c.Host, link)
+	}
+	u, err := c.resolve(link[start+1 : end])
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// DeleteManifest deletes ref from repo.  Deleting a tag removes only that tag; deleting a digest removes
+// the manifest and every tag pointing at it.  A ref already gone is not an error
+func (c *RegistryClient) DeleteManifest(repo string, ref string) (err error) {
+	resp, err := c.do(repoScope(repo, "pull,push,delete"), func() (*http.Request, error) {
+		return http.NewRequest("DELETE", c.url("/v2/%s/manifests/%s", repo, ref), nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return responseError(resp)
+}
+
+// resolve turns a possibly relative upload Location into an absolute URL
+func (c *RegistryClient) resolve(location string) (*url.URL, error) {
+	if location == "" {
+		return nil, fmt.Errorf("registry %v: upload response missing Location header", c.Host)
+	}
+	base, err := url.Parse(c.url("/v2/"))
+	if err != nil {
+		return nil, err
+	}
+	ref, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	return base.ResolveReference(ref), nil
+}
+
+// do sends the request built by newReq under the retry policy.  A final 429 or 5xx response is returned
+// for the caller to report like any other unexpected status
+func (c *RegistryClient) do(scope string, newReq func() (*http.Request, error)) (resp *http.Response, err error) {
+	err = c.Retry.Do("registry "+c.Host, func() (err error) {
+		if resp != nil {
+			resp.Body.Close()
+			resp = nil
+		}
+		if resp, err = c.doOnce(scope, newReq); err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return fmt.Errorf("%v", resp.Status)
+		}
+		return nil
+	})
+
+	if resp != nil {
+		return resp, nil
+	}
+	return nil, err
+}
+
+// doOnce sends the request built by newReq, answering a single Basic or Bearer challenge before giving up
+func (c *RegistryClient) doOnce(scope string, newReq func() (*http.Request, error)) (*http.Response, error) {
+	c.mu.Lock()
+	if c.tokens == nil {
+		c.tokens = map[string]string{}
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = http.DefaultClient
+	}
+	c.mu.Unlock()
+
+	req, err := newReq()
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req, scope)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || c.Token != "" {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	switch {
+	case strings.HasPrefix(strings.ToLower(challenge), "bearer "):
+		token, err := c.fetchToken(challenge, scope)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.tokens[scope] = token
+		c.mu.Unlock()
+	case strings.HasPrefix(strings.ToLower(challenge), "basic ") && c.Username != "":
+		c.mu.Lock()
+		c.basic = true
+		c.mu.Unlock()
+	default:
+		return nil, fmt.Errorf("registry %v: unauthorized: %v", c.Host, challenge)
+	}
+
+	if req, err = newReq(); err != nil {
+		return nil, err
+	}
+	c.authorize(req, scope)
+	return c.HTTPClient.Do(req)
+}
+
+func (c *RegistryClient) authorize(req *http.Request, scope string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	case c.tokens[scope] != "":
+		req.Header.Set("Authorization", "Bearer "+c.tokens[scope])
+	case c.basic:
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+// fetchToken exchanges credentials for a bearer token at the realm named in the challenge
+func (c *RegistryClient) fetchToken(challenge string, scope string) (token string, err error) {
+	params := parseChallenge(challenge)
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return token, fmt.Errorf("registry %v: invalid auth challenge: %v", c.Host, challenge)
+	}
+
+	q := realm.Query()
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return token, err
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return token, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return token, responseError(resp)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return token, fmt.Errorf("registry %v: token response: %v", c.Host, err)
+	}
+
+	if token = body.Token; token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		err = fmt.Errorf("registry %v: token response missing token", c.Host)
+	}
+	return token, err
+}
+
+// parseChallenge splits a WWW-Authenticate header into its key="value" parameters
+func parseChallenge(challenge string) map[string]string {
+	params := map[string]string{}
+	if i := strings.Index(challenge, " "); i >= 0 {
+		challenge = challenge[i+1:]
+	}
+
+	for challenge != "" {
+		eq := strings.Index(challenge, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(challenge[:eq]))
+		rest := challenge[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+
+		params[key] = value
+		challenge = strings.TrimLeft(rest, ", ")
+	}
+	return params
+}
+
+// responseError summarizes an unexpected registry response, including any distribution API error body
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	var apiErr struct {
+		Errors []struct {
+			Code    string
+			Message string
+		}
+	}
+	if json.Unmarshal(body, &apiErr) == nil && len(apiErr.Errors) > 0 {
+		var msgs []string
+		for _, e := range apiErr.Errors {
+			msgs = append(msgs, e.Code+": "+e.Message)
+		}
+		return fmt.Errorf("%v %v: %v: %v", resp.Request.Method, resp.Request.URL, resp.Status, strings.Join(msgs, "; "))
+	}
+	return fmt.Errorf("%v %v: %v %v", resp.Request.Method, resp.Request.URL, resp.Status, strings.TrimSpace(string(body)))
+}
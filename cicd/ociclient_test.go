@@ -0,0 +1,188 @@
+package cicd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryClientTokenChallenge(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.Token = "secret-token"
+	c := reg.Client()
+
+	if err := c.Ping("team/app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Ping("team/app"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the token is fetched once per scope and then presented up front
+	want := []string{"GET /v2/", "GET /token", "GET /v2/", "GET /v2/"}
+	if got := reg.Requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+func TestRegistryClientStaticToken(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.Token = "secret-token"
+
+	c := reg.Client()
+	c.Token = "wrong-token"
+	if err := c.Ping("team/app"); err == nil {
+		t.Fatal("ping with a rejected static token succeeded")
+	}
+
+	c = reg.Client()
+	c.Token = "secret-token"
+	if err := c.Ping("team/app"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryClientUploadBlob(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.Token = "secret-token"
+	c := reg.Client()
+
+	blob := []byte("layer content")
+	d := Descriptor{Digest: digestOf(blob), Size: int64(len(blob))}
+	open := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(blob)), nil
+	}
+
+	if exists, err := c.BlobExists("team/app", d); err != nil || exists {
+		t.Fatalf("BlobExists before upload = %v, %v", exists, err)
+	}
+	if err := c.UploadBlob("team/app", d, open); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := c.BlobExists("team/app", d); err != nil || !exists {
+		t.Fatalf("BlobExists after upload = %v, %v", exists, err)
+	}
+
+	rc, err := c.GetBlob("team/app", d.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, _ := ioutil.ReadAll(rc); !bytes.Equal(got, blob) {
+		t.Errorf("GetBlob = %q, want %q", got, blob)
+	}
+
+	// the registry rejects content not matching the digest
+	bad := Descriptor{Digest: digestOf([]byte("other")), Size: int64(len(blob))}
+	if err := c.UploadBlob("team/app", bad, open); err == nil {
+		t.Error("upload with mismatched digest succeeded")
+	}
+}
+
+func TestRegistryClientManifest(t *testing.T) {
+	reg := newTestRegistry(t)
+	c := reg.Client()
+
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"` + MediaTypeOCIManifest + `"}`)
+	digest, err := c.PutManifest("team/app", "v1", MediaTypeOCIManifest, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != digestOf(manifest) {
+		t.Errorf("PutManifest digest = %v, want %v", digest, digestOf(manifest))
+	}
+
+	if got, err := c.ManifestDigest("team/app", "v1"); err != nil || got != digest {
+		t.Errorf("ManifestDigest = %v, %v, want %v", got, err, digest)
+	}
+	if got, err := c.ManifestDigest("team/app", "missing"); err != nil || got != "" {
+		t.Errorf("ManifestDigest of missing tag = %q, %v", got, err)
+	}
+
+	reg.NoDigestHeader = true
+	if got, err := c.ManifestDigest("team/app", "v1"); err != nil || got != digest {
+		t.Errorf("ManifestDigest without Docker-Content-Digest = %v, %v, want %v", got, err, digest)
+	}
+	reg.NoDigestHeader = false
+
+	mediaType, body, err := c.GetManifest("team/app", digest)
+	if err != nil || mediaType != MediaTypeOCIManifest || !bytes.Equal(body, manifest) {
+		t.Errorf("GetManifest = %v, %s, %v", mediaType, body, err)
+	}
+	if _, body, err := c.GetManifest("team/app", "missing"); err != nil || body != nil {
+		t.Errorf("GetManifest of missing tag = %s, %v", body, err)
+	}
+
+	if err := c.DeleteManifest("team/app", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteManifest("team/app", "v1"); err != nil {
+		t.Errorf("deleting a missing tag: %v", err)
+	}
+}
+
+func TestRegistryClientTagsPagination(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.PageSize = 2
+	c := reg.Client()
+
+	if tags, err := c.Tags("team/app"); err != nil || len(tags) != 0 {
+		t.Fatalf("Tags of missing repository = %v, %v", tags, err)
+	}
+
+	want := []string{"a", "b", "c", "d", "e"}
+	reg.PutManifest("team/app", MediaTypeOCIManifest, Manifest{SchemaVersion: 2}, want...)
+
+	tags, err := c.Tags("team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("Tags = %v, want %v", tags, want)
+	}
+
+	var pages int
+	for _, r := range reg.Requests() {
+		if strings.HasSuffix(r, "/tags/list") {
+			pages++
+		}
+	}
+	if pages != 4 {
+		t.Errorf("tag list requests = %d, want 4", pages)
+	}
+}
+
+func TestRegistryClientRetry(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	reg := newTestRegistry(t)
+	reg.FailNext("/v2/", 2)
+
+	c := reg.Client()
+	if err := c.Ping("team/app"); err == nil {
+		t.Fatal("ping without retries succeeded through a 503")
+	}
+
+	reg.FailNext("/v2/", 2)
+	c.Retry = Retry{Attempts: 3}
+	if err := c.Ping("team/app"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	got := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:a/b:pull,push"`)
+	want := map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:a/b:pull,push",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseChallenge = %v, want %v", got, want)
+	}
+}
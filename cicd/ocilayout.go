@@ -0,0 +1,376 @@
+package cicd
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	MediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIConfig   = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCILayer    = "application/vnd.oci.image.layer.v1.tar"
+
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// Descriptor references a blob or manifest by content digest
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Platform describes an image manifest listed in an index
+	Platform *Platform `json:"platform,omitempty"`
+}
+
+// Platform is the os and architecture an image in an index runs on
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Manifest is an OCI image manifest (or docker schema2 manifest, which shares its shape)
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Index is an OCI image index, as found in an OCI layout's index.json
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// LocalImage is an image manifest and its blobs read from a local OCI layout directory, an OCI layout
+// tarball or a `docker save` tarball
+type LocalImage struct {
+	MediaType string
+	Manifest  []byte
+	Blobs     []Descriptor
+
+	paths   map[string]string
+	tempDir string
+}
+
+// OpenLocalImage reads the image at path.  Tarballs are extracted to a temporary directory removed by Close
+func OpenLocalImage(path string) (li *LocalImage, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("image source: %v", err)
+	}
+
+	dir := path
+	var tempDir string
+	if !fi.IsDir() {
+		if tempDir, err = ioutil.TempDir("", "cicd-image."); err != nil {
+			return nil, err
+		}
+		if err = extractTar(path, tempDir); err != nil {
+			os.RemoveAll(tempDir)
+			return nil, fmt.Errorf("image source %v: %v", path, err)
+		}
+		dir = tempDir
+	}
+
+	switch {
+	case fileExists(filepath.Join(dir, "oci-layout")):
+		li, err = openLayout(dir)
+	case fileExists(filepath.Join(dir, "manifest.json")):
+		li, err = openDockerSave(dir)
+	default:
+		err = fmt.Errorf("not an OCI layout or docker save archive")
+	}
+
+	if err != nil {
+		if tempDir != "" {
+			os.RemoveAll(tempDir)
+		}
+		return nil, fmt.Errorf("image source %v: %v", path, err)
+	}
+
+	li.tempDir = tempDir
+	return li, err
+}
+
+// Digest is the content digest of the image manifest
+func (li *LocalImage) Digest() string {
+	return digestOf(li.Manifest)
+}
+
+// Open returns a reader for the blob described by d
+func (li *LocalImage) Open(d Descriptor) (io.ReadCloser, error) {
+	path, ok := li.paths[d.Digest]
+	if !ok {
+		return nil, fmt.Errorf("blob %v not found in image source", d.Digest)
+	}
+	return os.Open(path)
+}
+
+// Close removes any temporary extraction directory
+func (li *LocalImage) Close() error {
+	if li.tempDir != "" {
+		return os.RemoveAll(li.tempDir)
+	}
+	return nil
+}
+
+func openLayout(dir string) (li *LocalImage, err error) {
+	var index Index
+	if err = readJSON(filepath.Join(dir, "index.json"), &index); err != nil {
+		return nil, err
+	}
+	if len(index.Manifests) == 0 {
+		return nil, fmt.Errorf("index.json lists no manifests")
+	}
+
+	desc := index.Manifests[0]
+	if desc.MediaType == MediaTypeOCIIndex || desc.MediaType == MediaTypeDockerManifestList {
+		return nil, fmt.Errorf("image index %v not supported; expected a single image manifest", desc.Digest)
+	}
+
+	li = &LocalImage{MediaType: desc.MediaType, paths: map[string]string{}}
+	path, err := layoutBlobPath(dir, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if li.Manifest, err = ioutil.ReadFile(path); err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err = json.Unmarshal(li.Manifest, &m); err != nil {
+		return nil, fmt.Errorf("manifest %v: %v", desc.Digest, err)
+	}
+
+	for _, d := range append([]Descriptor{m.Config}, m.Layers...) {
+		if path, err = layoutBlobPath(dir, d.Digest); err != nil {
+			return nil, err
+		}
+		li.Blobs = append(li.Blobs, d)
+		li.paths[d.Digest] = path
+	}
+	return li, err
+}
+
+// openDockerSave converts the legacy `docker save` layout into an OCI manifest with uncompressed layers
+func openDockerSave(dir string) (li *LocalImage, err error) {
+	var saved []struct {
+		Config string
+		Layers []string
+	}
+	if err = readJSON(filepath.Join(dir, "manifest.json"), &saved); err != nil {
+		return nil, err
+	}
+	if len(saved) != 1 {
+		return nil, fmt.Errorf("docker save archive must hold exactly one image, found %d", len(saved))
+	}
+
+	li = &LocalImage{MediaType: MediaTypeOCIManifest, paths: map[string]string{}}
+
+	describe := func(name string, mediaType string) (d Descriptor, err error) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if !withinDir(dir, path) {
+			return d, fmt.Errorf("manifest.json: %v is outside the archive", name)
+		}
+		if d, err = describeFile(path, mediaType); err == nil {
+			li.paths[d.Digest] = path
+			li.Blobs = append(li.Blobs, d)
+		}
+		return d, err
+	}
+
+	m := Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIManifest}
+	if m.Config, err = describe(saved[0].Config, MediaTypeOCIConfig); err != nil {
+		return nil, err
+	}
+	for _, layer := range saved[0].Layers {
+		var d Descriptor
+		if d, err = describe(layer, MediaTypeOCILayer); err != nil {
+			return nil, err
+		}
+		m.Layers = append(m.Layers, d)
+	}
+
+	li.Manifest, err = json.Marshal(m)
+	return li, err
+}
+
+// layoutBlobPath returns the path of digest in the layout at dir.  Digests come from the layout's own json,
+// so anything but a well-formed sha256 digest is refused before it can name a path outside the blobs
+func layoutBlobPath(dir string, digest string) (string, error) {
+	if !digestRE.MatchString(digest) {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return filepath.Join(dir, "blobs", strings.Replace(digest, ":", string(filepath.Separator), 1)), nil
+}
+
+func describeFile(path string, mediaType string) (d Descriptor, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return d, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return d, err
+	}
+	return Descriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(h.Sum(nil)), Size: size}, err
+}
+
+func digestOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func readJSON(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%v: %v", filepath.Base(path), err)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// extractTar unpacks a plain or gzipped tarball into dir, refusing entries that escape it: names and
+// links must stay inside dir, links must be relative, nothing is written through a link, and every link
+// must still resolve inside dir once the archive is unpacked
+func extractTar(path string, dir string) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if magic, _ := r.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var links []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if !withinDir(dir, target) {
+			return fmt.Errorf("tar entry %v escapes archive root", hdr.Name)
+		}
+		if err = noLinkInPath(dir, target); err != nil {
+			return fmt.Errorf("tar entry %v: %v", hdr.Name, err)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = writeFile(target, tr)
+		case tar.TypeSymlink:
+			// docker save links layer.tar files to shared blobs; only follow links inside the archive
+			linked := filepath.Join(filepath.Dir(target), filepath.FromSlash(hdr.Linkname))
+			if filepath.IsAbs(hdr.Linkname) || !withinDir(dir, linked) {
+				return fmt.Errorf("tar entry %v links outside archive root", hdr.Name)
+			}
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+				err = os.Symlink(hdr.Linkname, target)
+			}
+			links = append(links, target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// links through other links can resolve differently than their names suggest
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		resolved, rerr := filepath.EvalSymlinks(link)
+		if rerr == nil && !withinDir(root, resolved) {
+			return fmt.Errorf("tar entry %v links outside archive root", strings.TrimPrefix(link, dir+string(filepath.Separator)))
+		}
+	}
+	return nil
+}
+
+// noLinkInPath refuses a target under dir reached through, or replacing, an existing symlink
+func noLinkInPath(dir string, target string) error {
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || rel == "." {
+		return err
+	}
+
+	p := dir
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, name)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("path through symlink %v", filepath.ToSlash(strings.TrimPrefix(p, dir+string(filepath.Separator))))
+		}
+	}
+	return nil
+}
+
+func withinDir(dir string, path string) bool {
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+func writeFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
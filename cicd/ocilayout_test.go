@@ -0,0 +1,218 @@
+package cicd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarEntry is a file, directory or symlink written by writeTestTar
+type tarEntry struct {
+	Name, Content, Link string
+	Dir                 bool
+}
+
+func writeTestTar(t *testing.T, path string, gzipped bool, entries []tarEntry) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	if gzipped {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.Name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.Content))}
+		switch {
+		case e.Dir:
+			hdr = &tar.Header{Name: e.Name, Mode: 0755, Typeflag: tar.TypeDir}
+		case e.Link != "":
+			hdr = &tar.Header{Name: e.Name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: e.Link}
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write([]byte(e.Content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// layoutEntries lists the files of the OCI layout in dir as tar entries
+func layoutEntries(t *testing.T, dir string) (entries []tarEntry) {
+	t.Helper()
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		rel, _ := filepath.Rel(dir, path)
+		entries = append(entries, tarEntry{Name: filepath.ToSlash(rel), Content: string(b)})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestOpenLocalImageLayout(t *testing.T) {
+	dir := t.TempDir()
+	digest := writeTestLayout(t, dir, "layer data")
+
+	tarball := filepath.Join(t.TempDir(), "image.tar")
+	writeTestTar(t, tarball, false, layoutEntries(t, dir))
+	gzipped := filepath.Join(t.TempDir(), "image.tar.gz")
+	writeTestTar(t, gzipped, true, layoutEntries(t, dir))
+
+	for _, path := range []string{dir, tarball, gzipped} {
+		li, err := OpenLocalImage(path)
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		if li.Digest() != digest || li.MediaType != MediaTypeOCIManifest || len(li.Blobs) != 2 {
+			t.Errorf("%v: digest %v, media type %v, blobs %v", path, li.Digest(), li.MediaType, li.Blobs)
+		}
+
+		rc, err := li.Open(li.Blobs[1])
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		if b, _ := ioutil.ReadAll(rc); string(b) != "layer data" {
+			t.Errorf("%v: layer = %q", path, b)
+		}
+		rc.Close()
+
+		tempDir := li.tempDir
+		li.Close()
+		if tempDir != "" && fileExists(tempDir) {
+			t.Errorf("%v: extraction directory %v not removed", path, tempDir)
+		}
+	}
+}
+
+func TestOpenLocalImageDockerSave(t *testing.T) {
+	tarball := filepath.Join(t.TempDir(), "saved.tar")
+	writeTestTar(t, tarball, false, []tarEntry{
+		{Name: "manifest.json", Content: `[{"Config":"config.json","RepoTags":["app:abc"],"Layers":["l1/layer.tar","l2/layer.tar"]}]`},
+		{Name: "config.json", Content: `{"architecture":"amd64","os":"linux"}`},
+		{Name: "l1/layer.tar", Content: "first layer"},
+		{Name: "shared/layer.tar", Content: "shared layer"},
+		{Name: "l2", Dir: true},
+		{Name: "l2/layer.tar", Link: "../shared/layer.tar"},
+	})
+
+	li, err := OpenLocalImage(tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer li.Close()
+
+	if len(li.Blobs) != 3 {
+		t.Fatalf("blobs = %v", li.Blobs)
+	}
+	if li.Blobs[0].MediaType != MediaTypeOCIConfig || li.Blobs[2].Digest != digestOf([]byte("shared layer")) {
+		t.Errorf("blobs = %v", li.Blobs)
+	}
+	if !strings.Contains(string(li.Manifest), li.Blobs[2].Digest) {
+		t.Errorf("manifest %s does not reference linked layer", li.Manifest)
+	}
+}
+
+func TestOpenLocalImageNotAnImage(t *testing.T) {
+	tarball := filepath.Join(t.TempDir(), "other.tar")
+	writeTestTar(t, tarball, false, []tarEntry{{Name: "README", Content: "hello"}})
+
+	if _, err := OpenLocalImage(tarball); err == nil {
+		t.Fatal("opened a tarball that is not an image")
+	}
+}
+
+func TestOpenLocalImageRefusesEscapes(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	writeTestFile(t, secret, `{"schemaVersion":2,"layers":[]}`)
+
+	// a manifest digest naming a path outside the layout
+	layout := t.TempDir()
+	rel, _ := filepath.Rel(filepath.Join(layout, "blobs", "sha256"), secret)
+	writeTestFile(t, filepath.Join(layout, "index.json"),
+		`{"schemaVersion":2,"manifests":[{"mediaType":"`+MediaTypeOCIManifest+`","digest":"sha256:`+filepath.ToSlash(rel)+`"}]}`)
+	writeTestFile(t, filepath.Join(layout, "oci-layout"), `{"imageLayoutVersion":"1.0.0"}`)
+
+	// a layer digest naming a path outside the layout
+	layers := t.TempDir()
+	digest := writeTestLayout(t, layers, "layer data")
+	path, _ := layoutBlobPath(layers, digest)
+	writeTestFile(t, path, `{"schemaVersion":2,"config":{"digest":"sha256:../../../etc/passwd"},"layers":[]}`)
+
+	// a docker save manifest naming a file outside the archive
+	saved := t.TempDir()
+	writeTestFile(t, filepath.Join(saved, "manifest.json"), `[{"Config":"../`+filepath.Base(filepath.Dir(secret))+`/secret","Layers":[]}]`)
+
+	for _, dir := range []string{layout, layers, saved} {
+		if li, err := OpenLocalImage(dir); err == nil {
+			t.Errorf("%v: opened image reading %v", dir, li.paths)
+		}
+	}
+}
+
+func TestExtractTarRefusesEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries func(outside string) []tarEntry
+	}{
+		{"parent name", func(outside string) []tarEntry {
+			return []tarEntry{{Name: "../pwned", Content: "x"}}
+		}},
+		{"absolute link", func(outside string) []tarEntry {
+			return []tarEntry{{Name: "evil", Link: outside}, {Name: "evil/pwned", Content: "x"}}
+		}},
+		{"relative link", func(outside string) []tarEntry {
+			return []tarEntry{{Name: "evil", Link: "../outside"}, {Name: "evil/pwned", Content: "x"}}
+		}},
+		{"write through link", func(outside string) []tarEntry {
+			return []tarEntry{{Name: "sub", Dir: true}, {Name: "evil", Link: "sub"}, {Name: "evil/pwned", Content: "x"}}
+		}},
+		{"replace link", func(outside string) []tarEntry {
+			return []tarEntry{{Name: "file", Content: "x"}, {Name: "evil", Link: "file"}, {Name: "evil", Content: "y"}}
+		}},
+		{"link through link", func(outside string) []tarEntry {
+			return []tarEntry{{Name: "a/b", Dir: true}, {Name: "a/root", Link: ".."}, {Name: "a/b/up", Link: "../root/.."}}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			dir, outside := filepath.Join(base, "extract"), filepath.Join(base, "outside")
+			for _, d := range []string{dir, outside} {
+				if err := os.Mkdir(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tarball := filepath.Join(base, "evil.tar")
+			writeTestTar(t, tarball, false, tt.entries(outside))
+
+			if err := extractTar(tarball, dir); err == nil {
+				t.Error("extractTar accepted an escaping archive")
+			}
+			if fileExists(filepath.Join(outside, "pwned")) || fileExists(filepath.Join(base, "pwned")) {
+				t.Error("extractTar wrote outside the archive root")
+			}
+		})
+	}
+}
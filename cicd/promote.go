@@ -0,0 +1,292 @@
+package cicd
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// Promote copies an image already pushed to the PromoteFrom registry, by tag or digest, to the
+// PromoteTo registry, or its PromoteRepo repository, through the distribution API, without a local pull
+// or rebuild.  The copy is tagged with the source tag, if any, and each environment tag
+func (wf *Workflow) Promote() (result PushResult, err error) {
+	opts := wf.Options
+
+	var tags []string
+	if tags, err = wf.validatePromoteOptions(); err != nil {
+		return result, err
+	}
+
+	src, srcURL, err := wf.promoteRegistry(opts.PromoteFrom)
+	if err != nil {
+		return result, err
+	}
+	dst, dstURL, err := wf.promoteRegistry(opts.PromoteTo)
+	if err != nil {
+		return result, err
+	}
+
+	// another repository of the destination registry is reached with the same credentials
+	if opts.PromoteRepo != "" {
+		var repo Reference
+		if repo, err = ParseRepository(opts.PromoteRepo); err != nil {
+			return result, fmt.Errorf("--to-repo: %v", err)
+		}
+		if host, _ := splitRepoURL(dstURL); repo.APIHost() != host {
+			return result, fmt.Errorf("--to-repo %v is not in the %v registry %v", opts.PromoteRepo, opts.PromoteTo, dstURL)
+		}
+		dstURL = repo.Repository()
+	}
+
+	_, srcRepo := splitRepoURL(srcURL)
+	_, dstRepo := splitRepoURL(dstURL)
+
+	// resolve the source image
+	ref := opts.Tag
+	if opts.Digest != "" {
+		ref = opts.Digest
+	}
+	mediaType, body, err := src.GetManifest(srcRepo, ref)
+	if err != nil {
+		return result, err
+	}
+	if body == nil {
+		return result, fmt.Errorf("promote: %v not found in %v", ref, srcURL)
+	}
+	digest := digestOf(body)
+	if opts.Digest != "" && digest != opts.Digest {
+		return result, fmt.Errorf("promote: %v@%v resolved to manifest %v", srcURL, opts.Digest, digest)
+	}
+
+	// a tag given with the digest must still name that image in the source
+	if opts.Tag != "" && opts.Digest != "" {
+		var tagged string
+		if tagged, err = src.ManifestDigest(srcRepo, opts.Tag); err != nil {
+			return result, err
+		}
+		switch {
+		case tagged == "":
+			return result, fmt.Errorf("promote: %v:%v not found", srcURL, opts.Tag)
+		case tagged != opts.Digest:
+			return result, fmt.Errorf("promote: %v:%v is %v, not the given digest %v", srcURL, opts.Tag, tagged, opts.Digest)
+		}
+	}
+	log.Println("promote:", srcURL+"@"+digest, "to", dstURL, tags)
+
+	var images []string
+	dstRef, err := ParseRepository(dstURL)
+	if err != nil {
+		return result, err
+	}
+	for _, tag := range tags {
+		images = append(images, dstRef.WithTag(tag).String())
+	}
+
+	if wf.IsDryRun() {
+		log.Println("dryrun: copy", srcURL+"@"+digest, "as", images)
+		return result, err
+	}
+
+	// copy blobs and any platform manifests, then tag the image in the destination
+	p := promotion{src: src, dst: dst, srcRepo: srcRepo, dstRepo: dstRepo, concurrency: wf.Config.Provider.Registry.Concurrency}
+	if err = p.copyContent(mediaType, body); err != nil {
+		return result, err
+	}
+
+	result, err = pushEach(images, p.concurrency, func(image string) (pi PushedImage, unchanged bool, err error) {
+		tag := tagOf(dstURL, image)
+		pi = PushedImage{Digest: digest, Size: int64(len(body))}
+
+		var remote string
+		if remote, err = dst.ManifestDigest(dstRepo, tag); err != nil || remote == digest {
+			return pi, err == nil, err
+		}
+		if pi.Digest, err = dst.PutManifest(dstRepo, tag, mediaType, body); err != nil {
+			return pi, false, err
+		}
+		return pi, false, err
+	})
+	wf.logPushResult(result)
+	if err != nil {
+		return result, err
+	}
+
+	// signatures stored alongside the image travel with it.  they name the source repository, so the
+	// destination is signed for itself when a signing key is configured
+	if err = p.copySignature(digest); err != nil {
+		return result, err
+	}
+	if key := wf.App.Signing.Key; key != "" {
+		var signer crypto.Signer
+		if signer, err = loadPrivateKey(key); err != nil {
+			return result, err
+		}
+		if err = signImage(dst, dstURL, digest, signer); err != nil {
+			return result, fmt.Errorf("sign %v@%v: %v", dstURL, digest, err)
+		}
+	}
+
+	// save results for deploy to pin digests
+	if opts.ResultFile != "" {
+		if err = WritePushResults(opts.ResultFile, PushResults{{Registry: opts.PromoteTo, PushResult: result}}); err != nil {
+			return result, err
+		}
+		log.Println("push result file:", opts.ResultFile)
+	}
+	return result, err
+}
+
+// validatePromoteOptions returns the destination tags: the source tag followed by environment tags
+func (wf *Workflow) validatePromoteOptions() (tags []string, err error) {
+	opts := wf.Options
+
+	switch {
+	case opts.PromoteFrom == "" || opts.PromoteTo == "":
+		return tags, fmt.Errorf("%v", "source and destination registries required; use --from and --to options")
+	case opts.Tag == "" && opts.Digest == "":
+		return tags, fmt.Errorf("%v", "image to promote required; use --tag or --digest option")
+	case opts.Digest != "" && !digestRE.MatchString(opts.Digest):
+		return tags, fmt.Errorf("image digest invalid: %q", opts.Digest)
+	}
+
+	for _, tag := range append([]string{opts.Tag}, opts.EnvTags...) {
+		if tag == "" || contains(tags, tag) {
+			continue
+		}
+		if !tagRE.MatchString(tag) {
+			return nil, fmt.Errorf("tag invalid: %q", tag)
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return tags, fmt.Errorf("%v", "promoting by digest requires an environment tag; use --env option")
+	}
+	return tags, err
+}
+
+// promoteRegistry validates and authenticates the configured registry id, returning its api client
+func (wf *Workflow) promoteRegistry(id string) (c *RegistryClient, repoURL string, err error) {
+	var r interface{}
+	if r, err = wf.getRegistry(id); err != nil {
+		return c, repoURL, err
+	}
+	ar := r.(Registrator)
+
+	if err = ar.IsRegistryValid(); err != nil {
+		return c, repoURL, err
+	}
+	wf.loginMu.Lock()
+	err = ar.Authenticate()
+	wf.loginMu.Unlock()
+	if err != nil {
+		return c, repoURL, err
+	}
+
+	repoURL = ar.GetRepoURL()
+	if api, ok := ar.(RegistryAPI); ok {
+		c = api.Client()
+	}
+	if c == nil {
+		return c, repoURL, fmt.Errorf("%v: registry api unavailable for promotion", id)
+	}
+	return c, repoURL, err
+}
+
+// promotion copies manifest content between repositories of two registries
+type promotion struct {
+	src, dst         *RegistryClient
+	srcRepo, dstRepo string
+	concurrency      int
+}
+
+// copyContent copies what manifest references: the platform manifests of an index, or the config and
+// layer blobs of an image manifest
+func (p promotion) copyContent(mediaType string, body []byte) (err error) {
+	if mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerManifestList {
+		var index Index
+		if err = json.Unmarshal(body, &index); err != nil {
+			return fmt.Errorf("promote: image index: %v", err)
+		}
+		for _, m := range index.Manifests {
+			if err = p.copyManifest(m.Digest); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	var m Manifest
+	if err = json.Unmarshal(body, &m); err != nil {
+		return fmt.Errorf("promote: manifest: %v", err)
+	}
+
+	var blobs []Descriptor
+	for _, d := range append([]Descriptor{m.Config}, m.Layers...) {
+		// non-distributable layers are fetched from their own urls and never stored in a registry
+		if strings.Contains(d.MediaType, "foreign") || strings.Contains(d.MediaType, "nondistributable") {
+			continue
+		}
+		blobs = append(blobs, d)
+	}
+
+	_, err = pushEach(blobDigests(blobs), p.concurrency, func(digest string) (pi PushedImage, unchanged bool, err error) {
+		return pi, false, p.copyBlob(blobByDigest(blobs, digest))
+	})
+	return err
+}
+
+// copyManifest copies the manifest with digest and its content, storing it by digest
+func (p promotion) copyManifest(digest string) (err error) {
+	if remote, err := p.dst.ManifestDigest(p.dstRepo, digest); err != nil || remote == digest {
+		return err
+	}
+
+	mediaType, body, err := p.src.GetManifest(p.srcRepo, digest)
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		return fmt.Errorf("promote: manifest %v not found in source", digest)
+	}
+	if err = p.copyContent(mediaType, body); err != nil {
+		return err
+	}
+	_, err = p.dst.PutManifest(p.dstRepo, digest, mediaType, body)
+	return err
+}
+
+// copyBlob streams a blob missing from the destination straight from the source registry
+func (p promotion) copyBlob(d Descriptor) (err error) {
+	var exists bool
+	if exists, err = p.dst.BlobExists(p.dstRepo, d); err != nil || exists {
+		return err
+	}
+
+	log.Println("copying blob:", d.Digest, d.Size, "bytes")
+	return p.dst.UploadBlob(p.dstRepo, d, func() (io.ReadCloser, error) {
+		return p.src.GetBlob(p.srcRepo, d.Digest)
+	})
+}
+
+// copySignature copies the signature manifest of digest, if the image is signed and the destination
+// holds no signatures of its own
+func (p promotion) copySignature(digest string) (err error) {
+	// keep signatures already made in the destination
+	var existing string
+	if existing, err = p.dst.ManifestDigest(p.dstRepo, signatureTag(digest)); err != nil || existing != "" {
+		return err
+	}
+
+	mediaType, body, err := p.src.GetManifest(p.srcRepo, signatureTag(digest))
+	if err != nil || body == nil {
+		return err
+	}
+	if err = p.copyContent(mediaType, body); err != nil {
+		return err
+	}
+	_, err = p.dst.PutManifest(p.dstRepo, signatureTag(digest), mediaType, body)
+	return err
+}
@@ -0,0 +1,103 @@
+package cicd
+
+import (
+	"strings"
+	"testing"
+)
+
+// newPromoteWorkflow returns a workflow promoting from team/app of reg to team/app-prod of the same registry
+func newPromoteWorkflow(reg *testRegistry) *Workflow {
+	wf := New()
+	wf.Provider.Registry.OCI.Url = reg.Host() + "/team/app"
+	wf.Provider.Registry.OCI.Insecure = true
+	wf.Options = Options{PromoteFrom: "oci", PromoteTo: "oci", PromoteRepo: reg.Host() + "/team/app-prod", EnvTags: []string{"prod"}}
+	return wf
+}
+
+func TestPromoteToRepository(t *testing.T) {
+	reg := newTestRegistry(t)
+	digest := reg.PutImage("team/app", "2024-01-01T00:00:00Z", "v1").Digest
+
+	wf := newPromoteWorkflow(reg)
+	wf.Options.Tag = "v1"
+	result, err := wf.Promote()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Pushed) != 2 || result.Pushed[0].Digest != digest {
+		t.Errorf("promoted %+v", result)
+	}
+	for _, tag := range []string{"v1", "prod"} {
+		if m := reg.Manifest("team/app-prod", tag); m == nil || digestOf(m) != digest {
+			t.Errorf("team/app-prod:%v not promoted", tag)
+		}
+	}
+	if reg.Manifest("team/app", "prod") != nil {
+		t.Error("environment tag applied to the source repository")
+	}
+}
+
+func TestPromoteOptions(t *testing.T) {
+	reg := newTestRegistry(t)
+	v1 := reg.PutImage("team/app", "2024-01-01T00:00:00Z", "v1").Digest
+	v2 := reg.PutImage("team/app", "2024-02-01T00:00:00Z", "v2").Digest
+
+	tests := []struct {
+		name, tag, digest, repo string
+		err                     string
+	}{
+		{"tag and digest agree", "v1", v1, "", ""},
+		{"digest only", "", v2, "", ""},
+		{"tag moved from digest", "v2", v1, "", "not the given digest"},
+		{"tag missing", "v3", v1, "", "v3 not found"},
+		{"repository in another registry", "v1", "", "registry.example.com/team/app-prod", "is not in the oci registry"},
+		{"repository with tag", "v1", "", reg.Host() + "/team/app-prod:v1", "--to-repo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := newPromoteWorkflow(reg)
+			wf.Options.Tag, wf.Options.Digest = tt.tag, tt.digest
+			if tt.repo != "" {
+				wf.Options.PromoteRepo = tt.repo
+			}
+
+			_, err := wf.Promote()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatal(err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("Promote error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestPromoteSignatures(t *testing.T) {
+	private, public := writeTestKeys(t)
+
+	for _, resign := range []bool{false, true} {
+		reg := newTestRegistry(t)
+		digest := signTestImage(t, reg, "team/app", private)
+
+		wf := newPromoteWorkflow(reg)
+		wf.Options.Tag = "v1"
+		if resign {
+			wf.App.Signing.Key = private
+		}
+		if _, err := wf.Promote(); err != nil {
+			t.Fatal(err)
+		}
+
+		// the copied signature names the source repository; only a new signature verifies in the destination
+		if reg.Manifest("team/app-prod", signatureTag(digest)) == nil {
+			t.Fatal("signature not copied")
+		}
+		err := verifyImage(reg.Client(), reg.Host()+"/team/app-prod", digest, []string{public})
+		if resign && err != nil {
+			t.Errorf("re-signed promotion: %v", err)
+		}
+		if !resign && (err == nil || !strings.Contains(err.Error(), "signed for")) {
+			t.Errorf("copied signature verified in another repository: %v", err)
+		}
+	}
+}
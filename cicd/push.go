@@ -0,0 +1,325 @@
+package cicd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Push tags the base image according to the build event and pushes the tags to each active registry.
+// Results are reported per registry; with the continue policy every registry is attempted and the
+// returned error names each one that failed
+func (wf *Workflow) Push() (results PushResults, err error) {
+	opts := wf.Options
+
+	// detect build context from CI, then validate options
+	var dirty bool
+	if dirty, err = wf.applyBuildInfo(&opts); err != nil {
+		return results, err
+	}
+	if err = wf.validatePushOptions(opts); err != nil {
+		return results, err
+	}
+
+	// an image built from uncommitted changes does not match the commit it is tagged with
+	if dirty && !opts.Force {
+		if !wf.IsDryRun() {
+			return results, fmt.Errorf("%v", "refusing to push an image built from a dirty git working tree; commit the changes or use --force")
+		}
+		log.Println("dryrun: git working tree is dirty; push would be refused without --force")
+	}
+
+	// initialize active Registries indicated by config
+	var activeRegistries []interface{}
+	if activeRegistries, err = wf.GetActiveRegistries(); err != nil {
+		return results, err
+	}
+
+	var keepGoing bool
+	switch policy := wf.Config.Provider.Registry.OnError; policy {
+	case "", "abort":
+	case "continue":
+		keepGoing = true
+	default:
+		return results, fmt.Errorf("unknown registry onerror policy: <%v>", policy)
+	}
+
+	// push to registries concurrently up to the limit; under the abort policy no registry is started
+	// once another has failed
+	limit := wf.Config.Provider.Registry.RegistryConcurrency
+	if limit < 1 {
+		limit = 1
+	}
+
+	ids := wf.activeRegistryIDs()
+	errs := make([]error, len(activeRegistries))
+	attempted := make([]bool, len(activeRegistries))
+	pushed := make([]PushResult, len(activeRegistries))
+
+	var mu sync.Mutex
+	var aborted bool
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, limit)
+	for i, activeRegistry := range activeRegistries {
+		sem <- struct{}{}
+
+		mu.Lock()
+		stop := aborted
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, ar Registrator) {
+			defer func() { <-sem; wg.Done() }()
+
+			pushed[i], errs[i] = wf.pushRegistry(ar, opts)
+			attempted[i] = true
+			if errs[i] != nil && !keepGoing {
+				mu.Lock()
+				aborted = true
+				mu.Unlock()
+			}
+		}(i, activeRegistry.(Registrator))
+	}
+	wg.Wait()
+
+	var failed []string
+	var pushErr error
+	for i := range activeRegistries {
+		if !attempted[i] {
+			continue
+		}
+		rr := RegistryResult{Registry: ids[i], PushResult: pushed[i]}
+		if errs[i] != nil {
+			pushErr = errs[i]
+			rr.Error = errs[i].Error()
+			failed = append(failed, fmt.Sprintf("%v: %v", ids[i], strings.TrimSpace(errs[i].Error())))
+		}
+		results = append(results, rr)
+	}
+
+	// the active registry holds the build cache
+	if wf.App.Build.Cache.Mode == "registry" && attempted[0] && errs[0] == nil {
+		wf.exportBuildCache(activeRegistries[0].(Registrator), opts)
+	}
+
+	// save results for deploy to pin digests
+	if opts.ResultFile != "" {
+		if err = WritePushResults(opts.ResultFile, results); err != nil {
+			return results, err
+		}
+		log.Println("push result file:", opts.ResultFile)
+	}
+
+	switch {
+	case len(activeRegistries) == 1:
+		err = pushErr
+	case len(failed) > 0:
+		err = fmt.Errorf("push failed for %d registries:\n%v", len(failed), strings.Join(failed, "\n"))
+	}
+	return results, err
+}
+
+// pushRegistry tags and pushes images to a single registry
+func (wf *Workflow) pushRegistry(ar Registrator, opts Options) (result PushResult, err error) {
+
+	// validate registry has required values
+	if err = ar.IsRegistryValid(); err != nil {
+		return result, err
+	}
+
+	// authenticate credentials for registry
+	wf.loginMu.Lock()
+	err = ar.Authenticate()
+	wf.loginMu.Unlock()
+	if err != nil {
+		return result, err
+	}
+
+	// make list of images to tag
+	var images []string
+	if images, err = wf.makeTagList(ar.GetRepoURL(), opts); err != nil {
+		return result, err
+	}
+	// keep floating release tags on the highest released version
+	if contains(releaseEvents, opts.Event) {
+		if images, err = wf.protectFloatingTags(ar, images, opts); err != nil {
+			return result, err
+		}
+	}
+	if len(images) == 0 {
+		return result, fmt.Errorf("no images to tag: %v", images)
+	}
+
+	// multi-architecture images are published as an image index under each tag
+	if len(opts.Platforms) > 0 {
+		if result, err = wf.pushPlatforms(ar, images, opts); err != nil {
+			return result, err
+		}
+		wf.logPushResult(result)
+		return result, wf.signPushed(ar, result)
+	}
+
+	// tag images locally unless the registry pushes from an image source directly
+	if sp, ok := ar.(SourcePusher); ok {
+		if opts.Source == "" {
+			return result, fmt.Errorf("%v", "registry pushes from an OCI layout or tarball; use --source option")
+		}
+		sp.SetSource(opts.Source)
+	} else {
+		if err = wf.tagImages(opts.Image, images); err != nil {
+			return result, err
+		}
+		log.Println("tagged images:", images)
+	}
+
+	// push tagged images
+	if result, err = ar.Push(images); err != nil {
+		return result, err
+	}
+	wf.logPushResult(result)
+
+	// sign pushed digests when a signing key is configured
+	return result, wf.signPushed(ar, result)
+}
+
+func (wf *Workflow) logPushResult(result PushResult) {
+	for _, pi := range result.Pushed {
+		log.Println("pushed image:", pi.Ref, pi.Digest, pi.Duration)
+	}
+	if len(result.Unchanged) > 0 {
+		log.Println("unchanged images:", Refs(result.Unchanged))
+	}
+}
+
+// makeTagList renders the tag policy for the build into image references in repoURL
+func (wf *Workflow) makeTagList(repoURL string, opts Options) (images []string, err error) {
+	repo, err := ParseRepository(repoURL)
+	if err != nil {
+		return images, err
+	}
+
+	// the base image tag identifies the commit
+	base, err := ParseReference(opts.Image)
+	if err != nil {
+		return images, fmt.Errorf("--image: %v", err)
+	}
+
+	// tag images based on the configured (or default) tag policy
+	rules := wf.App.Tags
+	if len(rules) == 0 {
+		rules = defaultTagRules
+	}
+
+	var tags []string
+	if tags, err = renderTags(rules, tagVars(opts, base.Tag)); err != nil {
+		return images, err
+	}
+
+	for _, tag := range tags {
+		if !tagRE.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q for %v", tag, repo.Repository())
+		}
+		images = append(images, repo.WithTag(tag).String())
+	}
+	return images, err
+}
+
+// protectFloatingTags drops the MAJOR.MINOR, MAJOR and latest tags from images when the registry already
+// holds a higher release they belong to, e.g. a 1.3.5 patch release leaves 1 and latest on 1.4.0
+func (wf *Workflow) protectFloatingTags(ar Registrator, images []string, opts Options) (kept []string, err error) {
+	v, err := ParseSemver(opts.Version)
+	if err != nil {
+		return images, err
+	}
+	floating := v.floatingTags(opts.Latest)
+	if len(floating) == 0 {
+		return images, err
+	}
+
+	tl, ok := ar.(TagLister)
+	if !ok {
+		return images, fmt.Errorf("%v: registry cannot list tags to protect floating release tags", ar.GetRepoURL())
+	}
+	var existing []string
+	if existing, err = tl.ListTags(); err != nil {
+		if wf.IsDryRun() {
+			log.Println("dryrun: list tags:", err)
+			return images, nil
+		}
+		return images, fmt.Errorf("list tags: %v", err)
+	}
+
+	repoURL := ar.GetRepoURL()
+	for _, image := range images {
+		tag := tagOf(repoURL, image)
+		if contains(floating, tag) {
+			if newer, found := v.newerRelease(tag, existing); found {
+				log.Printf("release %v: not moving tag %v from newer release %v\n", v, tag, newer)
+				continue
+			}
+		}
+		kept = append(kept, image)
+	}
+	return kept, err
+}
+
+func (wf *Workflow) tagImages(base string, images []string) (err error) {
+
+	for _, image := range images {
+		if _, err = wf.Executor().Execute(Command{Name: "docker", Args: []string{"tag", base, image}}); err != nil {
+			break
+		}
+	}
+
+	return err
+}
+
+func (wf *Workflow) validatePushOptions(opts Options) (err error) {
+
+	switch {
+	case opts.Image == "":
+		err = fmt.Errorf("%v", "build image a required value; use --image option")
+
+	case imageError(opts.Image) != nil:
+		err = imageError(opts.Image)
+
+	case !(opts.Event == "push" || opts.Event == "pull_request" || contains(releaseEvents, opts.Event)):
+		err = fmt.Errorf("%v", "event type must be one of: push, pull_request, tag, release")
+
+	case opts.Branch == "" && !contains(releaseEvents, opts.Event):
+		err = fmt.Errorf("%v", "build branch a required value; use --branch option or configure a CI provider")
+
+	case opts.Event == "pull_request" && opts.PR == "":
+		err = fmt.Errorf("%v", "event type pull_request requires a PR number; use --pr option")
+
+	case contains(releaseEvents, opts.Event) && opts.Version == "":
+		err = fmt.Errorf("event type %v requires the release git tag; use --version option", opts.Event)
+
+	case contains(releaseEvents, opts.Event):
+		if _, verr := ParseSemver(opts.Version); verr != nil {
+			err = fmt.Errorf("--version: %v", verr)
+		}
+	}
+	if err == nil && len(opts.Platforms) > 0 {
+		_, err = parsePlatformImages(opts.Platforms)
+	}
+	return err
+
+}
+
+// imageError explains a base image that is malformed or carries no commit tag
+func imageError(image string) error {
+	ref, err := ParseReference(image)
+	switch {
+	case err != nil:
+		return fmt.Errorf("--image: %v", err)
+	case ref.Tag == "":
+		return fmt.Errorf("--image %q must be tagged with the commit, e.g. app:<sha>", image)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package cicd
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newPushWorkflow returns a workflow pushing app:abc123 from master to a docker registry
+func newPushWorkflow(t *testing.T) *Workflow {
+	t.Helper()
+	t.Setenv("DOCKER_USER", "user")
+	t.Setenv("DOCKER_PASSWORD", "s3cret")
+
+	wf := New()
+	wf.Provider.CI.LocalGit.Dir = t.TempDir() // not a git repository
+	wf.Config.Provider.Registry.ID = "docker"
+	wf.Provider.Registry.Docker.Url = "registry.example.com/team/app"
+	wf.Options = Options{Image: "app:abc123", Branch: "master", Event: "push", ResultFile: filepath.Join(t.TempDir(), "push.json")}
+	return wf
+}
+
+func dockerRecording(stdout string, err string, args ...string) Recording {
+	return Recording{Command: Command{Name: "docker", Args: args}, Stdout: stdout, Error: err}
+}
+
+func TestPush(t *testing.T) {
+	recordSleeps(t)
+	const repo = "registry.example.com/team/app"
+	login := dockerRecording("", "", "login", "-u", "user", "--password-stdin", "registry.example.com")
+	inspect := dockerRecording("", "Error: No such image", "image", "inspect", "--format", "{{.Id}}", repo+":abc123")
+	tag := func(tag string) Recording {
+		return dockerRecording("", "", "tag", "app:abc123", repo+":"+tag)
+	}
+	push := func(tag string, digest string) Recording {
+		return dockerRecording(tag+": digest: "+digest+" size: 528\n", "", "push", repo+":"+tag)
+	}
+
+	tests := []struct {
+		name       string
+		retry      Retry
+		recordings []Recording
+		digests    map[string]string
+		err        string
+	}{
+		{"pushed", Retry{}, []Recording{
+			login, tag("abc123"), tag("master"), tag("latest"), inspect,
+			push("abc123", testDigest1), push("master", testDigest1), push("latest", testDigest1),
+		}, map[string]string{"abc123": testDigest1, "master": testDigest1, "latest": testDigest1}, ""},
+		{"push failure", Retry{}, []Recording{
+			login, tag("abc123"), tag("master"), tag("latest"), inspect,
+			push("abc123", testDigest1), dockerRecording("", "denied: requested access to the resource is denied", "push", repo+":master"), push("latest", testDigest1),
+		}, map[string]string{"abc123": testDigest1, "latest": testDigest1}, "denied"},
+		{"transient failure retried", Retry{Attempts: 2}, []Recording{
+			login, tag("abc123"), tag("master"), tag("latest"), inspect,
+			push("abc123", testDigest1), dockerRecording("", "received unexpected HTTP status: 503 Service Unavailable", "push", repo+":master"),
+			push("master", testDigest1), push("latest", testDigest1),
+		}, map[string]string{"abc123": testDigest1, "master": testDigest1, "latest": testDigest1}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := newPushWorkflow(t)
+			wf.Config.Provider.Registry.Retry = tt.retry
+			e := NewReplayExecutor(tt.recordings)
+			wf.SetExecutor(e)
+
+			results, err := wf.Push()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatal(err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("Push error = %v, want %q", err, tt.err)
+			}
+
+			// the result file records what was pushed even when a push failed
+			saved, rerr := ReadPushResults(wf.Options.ResultFile)
+			if rerr != nil {
+				t.Fatal(rerr)
+			}
+			if !reflect.DeepEqual(saved, results) && tt.err == "" {
+				t.Errorf("result file %+v, returned %+v", saved, results)
+			}
+			digests := map[string]string{}
+			for _, pi := range saved[0].Pushed {
+				digests[tagOf(repo, pi.Ref)] = pi.Digest
+			}
+			if !reflect.DeepEqual(digests, tt.digests) {
+				t.Errorf("pushed digests = %v, want %v", digests, tt.digests)
+			}
+			if remaining := e.Remaining(); len(remaining) > 0 {
+				t.Errorf("commands not run: %v", remaining)
+			}
+		})
+	}
+}
+
+func TestPushDirtyWorkingTree(t *testing.T) {
+	// an unborn branch with a modified file
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, ".git", "HEAD"), "ref: refs/heads/master\n")
+	dirty := []Recording{
+		gitRecording(dir, " M main.go\n", "--no-optional-locks", "status", "--porcelain", "--untracked-files=no"),
+	}
+
+	wf := newPushWorkflow(t)
+	wf.Config.Provider.CI.ID = "git"
+	wf.Provider.CI.LocalGit.Dir = dir
+	wf.SetExecutor(NewReplayExecutor(dirty))
+	if _, err := wf.Push(); err == nil || !strings.Contains(err.Error(), "dirty") {
+		t.Fatalf("pushed from a dirty working tree: %v", err)
+	}
+
+	// dryrun reports the refusal without failing, and pushes nothing
+	wf.Options.DryRun = true
+	e := NewReplayExecutor(dirty)
+	wf.SetExecutor(e)
+	if _, err := wf.Push(); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := e.Remaining(); len(remaining) > 0 {
+		t.Errorf("commands not run: %v", remaining)
+	}
+}
+
+func TestPushRegistries(t *testing.T) {
+	t.Setenv("GCR_USER", "_json_key")
+	t.Setenv("GCR_PASSWORD", "gcr-s3cret")
+
+	// pushes of app:abc123 to the tags abc123, master and latest of repo, with a failed login when denied
+	pushRecordings := func(repo string, user string, denied bool) []Recording {
+		host := strings.SplitN(repo, "/", 2)[0]
+		if denied {
+			return []Recording{dockerRecording("", "unauthorized: incorrect username or password", "login", "-u", user, "--password-stdin", host)}
+		}
+		recordings := []Recording{dockerRecording("", "", "login", "-u", user, "--password-stdin", host)}
+		for _, tag := range []string{"abc123", "master", "latest"} {
+			recordings = append(recordings, dockerRecording("", "", "tag", "app:abc123", repo+":"+tag))
+		}
+		recordings = append(recordings, dockerRecording("", "Error: No such image", "image", "inspect", "--format", "{{.Id}}", repo+":abc123"))
+		for _, tag := range []string{"abc123", "master", "latest"} {
+			recordings = append(recordings, dockerRecording(tag+": digest: "+testDigest1+" size: 528\n", "", "push", repo+":"+tag))
+		}
+		return recordings
+	}
+	const (
+		docker = "registry.example.com/team/app"
+		gcr    = "gcr.io/project/app"
+	)
+
+	tests := []struct {
+		name         string
+		policy       string
+		dockerDenied bool
+		gcrDenied    bool
+		run          []Recording
+		skipped      []Recording
+		results      map[string]string
+		err          string
+	}{
+		{"all pushed", "", false, false,
+			append(pushRecordings(docker, "user", false), pushRecordings(gcr, "_json_key", false)...), nil,
+			map[string]string{"docker": "", "gcr": ""}, ""},
+		{"abort stops at the first failure", "abort", true, false,
+			pushRecordings(docker, "user", true), pushRecordings(gcr, "_json_key", false),
+			map[string]string{"docker": "unauthorized"}, "push failed for 1 registries:\ndocker: unauthorized"},
+		{"continue attempts every registry", "continue", true, false,
+			append(pushRecordings(docker, "user", true), pushRecordings(gcr, "_json_key", false)...), nil,
+			map[string]string{"docker": "unauthorized", "gcr": ""}, "docker: unauthorized"},
+		{"continue names each failure", "continue", true, true,
+			append(pushRecordings(docker, "user", true), pushRecordings(gcr, "_json_key", true)...), nil,
+			map[string]string{"docker": "unauthorized", "gcr": "unauthorized"}, "push failed for 2 registries"},
+		{"unknown policy", "retry", false, false, nil, nil, nil, "unknown registry onerror policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := newPushWorkflow(t)
+			wf.Config.Provider.Registry.IDs = []string{"docker", "gcr"}
+			wf.Config.Provider.Registry.OnError = tt.policy
+			wf.Provider.Registry.GCR.Url = gcr
+			wf.Provider.Registry.GCR.Credentials.Source = "env"
+			e := NewReplayExecutor(append(append([]Recording{}, tt.run...), tt.skipped...))
+			wf.SetExecutor(e)
+
+			results, err := wf.Push()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatal(err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("Push error = %v, want %q", err, tt.err)
+			}
+
+			// one result per attempted registry, recording its error
+			got := map[string]string{}
+			for _, rr := range results {
+				got[rr.Registry] = rr.Error
+				if rr.Error == "" && len(rr.Pushed) != 3 {
+					t.Errorf("%v: pushed %+v", rr.Registry, rr.Pushed)
+				}
+			}
+			if len(got) != len(tt.results) {
+				t.Errorf("results for %v, want %v", got, tt.results)
+			}
+			for id, want := range tt.results {
+				if msg, ok := got[id]; !ok || !strings.Contains(msg, want) || (want == "") != (msg == "") {
+					t.Errorf("%v: result error %q, want %q", id, msg, want)
+				}
+			}
+
+			if remaining := e.Remaining(); len(remaining) != len(tt.skipped) {
+				t.Errorf("commands not run: %v, want %d skipped", remaining, len(tt.skipped))
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package cicd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Recording captures a single Command and its outcome for later replay
+type Recording struct {
+	Command Command
+	Stdout  string `yaml:",omitempty"`
+	Stderr  string `yaml:",omitempty"`
+	Error   string `yaml:",omitempty"`
+}
+
+// RecordingExecutor passes commands to Next and keeps a Recording of each.  It is safe for concurrent use
+type RecordingExecutor struct {
+	Next       Executor
+	Recordings []Recording
+
+	mu sync.Mutex
+}
+
+func NewRecordingExecutor(next Executor) *RecordingExecutor {
+	return &RecordingExecutor{Next: next}
+}
+
+func (e *RecordingExecutor) Execute(c Command) (res Result, err error) {
+	res, err = e.Next.Execute(c)
+
+	// secrets echoed back in output or errors are masked like the arguments
+	rec := Recording{Command: c, Stdout: c.mask(string(res.Stdout)), Stderr: c.mask(string(res.Stderr))}
+	rec.Command.Args = maskArgs(c)
+	rec.Command.Secrets = nil
+	if c.Quiet && rec.Stdout != "" {
+		rec.Stdout = "********"
+	}
+	if err != nil {
+		rec.Error = c.mask(err.Error())
+	}
+	e.mu.Lock()
+	e.Recordings = append(e.Recordings, rec)
+	e.mu.Unlock()
+
+	return res, err
+}
+
+// Save writes recordings as yaml suitable for LoadReplayExecutor
+func (e *RecordingExecutor) Save(path string) error {
+	out, err := yaml.Marshal(e.Recordings)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0644)
+}
+
+// ReplayExecutor answers commands from recordings without running anything.  Each command is matched
+// to the earliest unused recording of the same command line, so concurrent pushes replay in any order
+type ReplayExecutor struct {
+	Recordings []Recording
+
+	mu   sync.Mutex
+	used []bool
+}
+
+func NewReplayExecutor(recordings []Recording) *ReplayExecutor {
+	return &ReplayExecutor{Recordings: recordings}
+}
+
+func LoadReplayExecutor(path string) (*ReplayExecutor, error) {
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var recordings []Recording
+	if err = yaml.Unmarshal(in, &recordings); err != nil {
+		return nil, fmt.Errorf("replay file %v: %v", path, err)
+	}
+	return NewReplayExecutor(recordings), nil
+}
+
+func (e *ReplayExecutor) Execute(c Command) (res Result, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.used == nil {
+		e.used = make([]bool, len(e.Recordings))
+	}
+
+	for i, rec := range e.Recordings {
+		if e.used[i] || rec.Command.String() != c.String() {
+			continue
+		}
+		e.used[i] = true
+
+		res = Result{Stdout: []byte(rec.Stdout), Stderr: []byte(rec.Stderr)}
+		if rec.Error != "" {
+			err = fmt.Errorf("%v", rec.Error)
+		}
+		return res, err
+	}
+	return res, fmt.Errorf("replay: unexpected command: %v", c)
+}
+
+// Remaining reports recordings not yet replayed
+func (e *ReplayExecutor) Remaining() (remaining []Recording) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, rec := range e.Recordings {
+		if e.used == nil || !e.used[i] {
+			remaining = append(remaining, rec)
+		}
+	}
+	return remaining
+}
+
+func maskArgs(c Command) []string {
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		args[i] = c.mask(a)
+	}
+	return args
+}
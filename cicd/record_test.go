@@ -0,0 +1,77 @@
+package cicd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMaskArgs(t *testing.T) {
+	c := Command{Name: "docker", Args: []string{"login", "-p", "s3cret", "--config=/tmp/s3cret"}, Secrets: []string{"s3cret"}}
+	want := []string{"login", "-p", "********", "--config=/tmp/********"}
+	if got := maskArgs(c); !reflect.DeepEqual(got, want) {
+		t.Errorf("maskArgs = %q, want %q", got, want)
+	}
+	if c.Args[2] != "s3cret" {
+		t.Error("maskArgs modified the command")
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	login := Command{Name: "tool", Args: []string{"login", "--password", "s3cret"}, Secrets: []string{"s3cret"}}
+	token := Command{Name: "tool", Args: []string{"token"}, Quiet: true}
+	push := Command{Name: "tool", Args: []string{"push", "app:abc"}}
+	verify := Command{Name: "tool", Args: []string{"verify"}, Secrets: []string{"s3cret"}}
+
+	next := NewReplayExecutor([]Recording{
+		{Command: Command{Name: "tool", Args: []string{"login", "--password", "********"}}, Stdout: "logged in with s3cret"},
+		{Command: token, Stdout: "registry-token"},
+		{Command: push, Stderr: "denied", Error: "denied: requested access to the resource is denied"},
+		{Command: verify, Stderr: "warning: s3cret expires soon", Error: "invalid credential s3cret"},
+	})
+	rec := NewRecordingExecutor(next)
+	for _, c := range []Command{login, token, push, verify} {
+		rec.Execute(c)
+	}
+
+	// secrets and quiet output never reach the recording
+	path := filepath.Join(t.TempDir(), "replay.yaml")
+	if err := rec.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(saved), "s3cret") || strings.Contains(string(saved), "registry-token") {
+		t.Errorf("recording holds secrets:\n%s", saved)
+	}
+
+	replay, err := LoadReplayExecutor(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := replay.Execute(login); err != nil || string(res.Stdout) != "logged in with ********" {
+		t.Errorf("replay login = %q, %v", res.Stdout, err)
+	}
+	if res, err := replay.Execute(token); err != nil || string(res.Stdout) != "********" {
+		t.Errorf("replay token = %q, %v", res.Stdout, err)
+	}
+	if res, err := replay.Execute(push); err == nil || string(res.Stderr) != "denied" {
+		t.Errorf("replay push = %q, %v", res.Stderr, err)
+	}
+
+	if res, err := replay.Execute(verify); err == nil || err.Error() != "invalid credential ********" || string(res.Stderr) != "warning: ******** expires soon" {
+		t.Errorf("replay verify = %q, %v", res.Stderr, err)
+	}
+
+	// each recording is replayed once
+	if _, err = replay.Execute(push); err == nil || !strings.Contains(err.Error(), "unexpected command") {
+		t.Errorf("second replay push: %v", err)
+	}
+	if remaining := replay.Remaining(); len(remaining) > 0 {
+		t.Errorf("recordings not replayed: %v", remaining)
+	}
+}
@@ -0,0 +1,164 @@
+package cicd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Reference is a parsed image reference of the form [host[:port]/]path[:tag][@digest]
+type Reference struct {
+	Host   string
+	Port   string
+	Path   string
+	Tag    string
+	Digest string
+}
+
+var (
+	pathComponentRE = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	hostRE          = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*$`)
+	portRE          = regexp.MustCompile(`^[0-9]{1,5}$`)
+	tagRE           = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	refDigestRE     = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]{32,}$`)
+)
+
+// ParseReference parses and validates s.  A leading component is a registry host only when it contains
+// a "." or ":" or is "localhost"; otherwise the reference is a Docker Hub short form
+func ParseReference(s string) (ref Reference, err error) {
+	invalid := func(reason string, a ...interface{}) (Reference, error) {
+		return Reference{}, fmt.Errorf("invalid image reference %q: %v", s, fmt.Sprintf(reason, a...))
+	}
+
+	if s == "" {
+		return invalid("empty")
+	}
+	rest := s
+
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		ref.Digest, rest = rest[i+1:], rest[:i]
+		if !refDigestRE.MatchString(ref.Digest) {
+			return invalid("malformed digest %q", ref.Digest)
+		}
+		if strings.HasPrefix(ref.Digest, "sha256:") && !digestRE.MatchString(ref.Digest) {
+			return invalid("sha256 digest must be 64 lowercase hex characters")
+		}
+	}
+
+	// a ":" after the last "/" separates the tag; earlier ones belong to a host port
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		ref.Tag, rest = rest[i+1:], rest[:i]
+		if !tagRE.MatchString(ref.Tag) {
+			return invalid("tag %q must be 1-128 characters of [A-Za-z0-9_.-] not starting with . or -", ref.Tag)
+		}
+	}
+
+	if i := strings.Index(rest, "/"); i >= 0 && (strings.ContainsAny(rest[:i], ".:") || rest[:i] == "localhost") {
+		host := rest[:i]
+		rest = rest[i+1:]
+
+		if j := strings.Index(host, ":"); j >= 0 {
+			ref.Host, ref.Port = host[:j], host[j+1:]
+			if !portRE.MatchString(ref.Port) {
+				return invalid("port %q must be numeric", ref.Port)
+			}
+		} else {
+			ref.Host = host
+		}
+		if !hostRE.MatchString(ref.Host) {
+			return invalid("malformed registry host %q", ref.Host)
+		}
+	}
+
+	if rest == "" {
+		return invalid("missing repository path")
+	}
+	for _, c := range strings.Split(rest, "/") {
+		if !pathComponentRE.MatchString(c) {
+			return invalid("path component %q must be lowercase alphanumerics separated by '.', '_', '__' or '-'", c)
+		}
+	}
+	ref.Path = rest
+
+	if len(ref.Repository()) > 255 {
+		return invalid("repository longer than 255 characters")
+	}
+	return ref, err
+}
+
+// ParseRepository parses a repository url, which must carry neither tag nor digest
+func ParseRepository(s string) (ref Reference, err error) {
+	if ref, err = ParseReference(s); err != nil {
+		return ref, err
+	}
+	if ref.Tag != "" || ref.Digest != "" {
+		return Reference{}, fmt.Errorf("invalid repository %q: must not include a tag or digest", s)
+	}
+	return ref, err
+}
+
+// Registry is the host[:port] as written, empty for Docker Hub short forms
+func (r Reference) Registry() string {
+	if r.Port != "" {
+		return r.Host + ":" + r.Port
+	}
+	return r.Host
+}
+
+// Repository is the reference without tag or digest, as written
+func (r Reference) Repository() string {
+	if reg := r.Registry(); reg != "" {
+		return reg + "/" + r.Path
+	}
+	return r.Path
+}
+
+func (r Reference) String() string {
+	s := r.Repository()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// WithTag returns the repository reference with tag, dropping any digest
+func (r Reference) WithTag(tag string) Reference {
+	r.Tag, r.Digest = tag, ""
+	return r
+}
+
+// WithDigest returns the repository reference pinned to digest, dropping any tag
+func (r Reference) WithDigest(digest string) Reference {
+	r.Tag, r.Digest = "", digest
+	return r
+}
+
+// APIHost and APIPath locate the repository on the distribution API, normalizing Docker Hub names
+func (r Reference) APIHost() string {
+	switch reg := r.Registry(); reg {
+	case "", "docker.io", "index.docker.io":
+		return dockerHubHost
+	default:
+		return reg
+	}
+}
+
+func (r Reference) APIPath() string {
+	if r.APIHost() == dockerHubHost && !strings.Contains(r.Path, "/") {
+		return "library/" + r.Path
+	}
+	return r.Path
+}
+
+const dockerHubHost = "registry-1.docker.io"
+
+// validRepoURL checks a registry's configured repository url
+func validRepoURL(description string, repoURL string) (err error) {
+	if _, err = ParseRepository(repoURL); err != nil {
+		err = fmt.Errorf("%v configuration: %v", description, err)
+	}
+	return err
+}
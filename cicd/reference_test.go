@@ -0,0 +1,82 @@
+package cicd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Reference
+		api  string
+	}{
+		{"app", Reference{Path: "app"}, "registry-1.docker.io/library/app"},
+		{"app:v1", Reference{Path: "app", Tag: "v1"}, "registry-1.docker.io/library/app"},
+		{"team/app", Reference{Path: "team/app"}, "registry-1.docker.io/team/app"},
+		{"docker.io/app", Reference{Host: "docker.io", Path: "app"}, "registry-1.docker.io/library/app"},
+		{"host:5000/name", Reference{Host: "host", Port: "5000", Path: "name"}, "host:5000/name"},
+		{"host:5000/name:5000", Reference{Host: "host", Port: "5000", Path: "name", Tag: "5000"}, "host:5000/name"},
+		{"localhost/x", Reference{Host: "localhost", Path: "x"}, "localhost/x"},
+		{"localhost:5000/x:latest", Reference{Host: "localhost", Port: "5000", Path: "x", Tag: "latest"}, "localhost:5000/x"},
+		{"gcr.io/p/app@" + testDigest1, Reference{Host: "gcr.io", Path: "p/app", Digest: testDigest1}, "gcr.io/p/app"},
+		{"gcr.io/p/app:v1@" + testDigest1, Reference{Host: "gcr.io", Path: "p/app", Tag: "v1", Digest: testDigest1}, "gcr.io/p/app"},
+		{"app@" + testDigest2, Reference{Path: "app", Digest: testDigest2}, "registry-1.docker.io/library/app"},
+		{"Registry.Example.com/team/app_x", Reference{Host: "Registry.Example.com", Path: "team/app_x"}, "Registry.Example.com/team/app_x"},
+		{"app:V1_rc.1", Reference{Path: "app", Tag: "V1_rc.1"}, "registry-1.docker.io/library/app"},
+	}
+	for _, tt := range tests {
+		ref, err := ParseReference(tt.in)
+		if err != nil {
+			t.Errorf("ParseReference(%q): %v", tt.in, err)
+			continue
+		}
+		if ref != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, want %+v", tt.in, ref, tt.want)
+		}
+		if ref.String() != tt.in {
+			t.Errorf("ParseReference(%q).String() = %q", tt.in, ref.String())
+		}
+		if api := ref.APIHost() + "/" + ref.APIPath(); api != tt.api {
+			t.Errorf("ParseReference(%q) api location = %q, want %q", tt.in, api, tt.api)
+		}
+	}
+}
+
+func TestParseReferenceInvalid(t *testing.T) {
+	tests := []struct {
+		in, err string
+	}{
+		{"", "empty"},
+		{"Team/App", "path component"},
+		{"gcr.io/Project/app", "path component"},
+		{"host:port/name", "port"},
+		{"host:5000/", "missing repository path"},
+		{"app:", "tag"},
+		{"app:-v1", "tag"},
+		{"app:" + strings.Repeat("v", 129), "tag"},
+		{"app@sha256:abc", "malformed digest"},
+		{"app@sha256:" + strings.Repeat("A", 64), "64 lowercase hex"},
+		{"@" + testDigest1, "missing repository path"},
+		{"team//app", "path component"},
+		{"app__-x", "path component"},
+		{"bad_host.io/app", "registry host"},
+		{"registry.example.com/" + strings.Repeat("a", 250), "longer than 255"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseReference(tt.in); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseReference(%q) error = %v, want %q", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestParseRepository(t *testing.T) {
+	if _, err := ParseRepository("host:5000/team/app"); err != nil {
+		t.Error(err)
+	}
+	for _, s := range []string{"team/app:v1", "team/app@" + testDigest1} {
+		if _, err := ParseRepository(s); err == nil {
+			t.Errorf("ParseRepository(%q) accepted a tag or digest", s)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package cicd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PushResult reports which images a Registrator pushed and which the registry already held unchanged
+type PushResult struct {
+	Pushed    []PushedImage `json:"pushed"`
+	Unchanged []PushedImage `json:"unchanged"`
+}
+
+// PushedImage describes one image reference handled by Push.  Size is the manifest size in bytes as
+// reported by the registry; Digest and Size are empty when not reported (e.g. in dryrun mode)
+type PushedImage struct {
+	Ref      string        `json:"ref"`
+	Digest   string        `json:"digest,omitempty"`
+	Size     int64         `json:"size,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// registryRuntime holds what a registry provider is given by the workflow (the executor, push concurrency
+// and retry policy) and the distribution API client it sets up in Authenticate
+type registryRuntime struct {
+	executor    Executor
+	client      *RegistryClient
+	concurrency int
+	retry       Retry
+}
+
+func (r *registryRuntime) SetExecutor(e Executor) {
+	r.executor = e
+}
+
+func (r *registryRuntime) SetConcurrency(n int) {
+	r.concurrency = n
+}
+
+func (r *registryRuntime) SetRetry(policy Retry) {
+	r.retry = policy
+}
+
+func (r *registryRuntime) Client() *RegistryClient {
+	return r.client
+}
+
+// newClient sets up the registry api client for host with the retry policy and basic credentials
+func (r *registryRuntime) newClient(host string, user string, pass string) *RegistryClient {
+	r.client = NewRegistryClient(host)
+	r.client.Retry = r.retry
+	r.client.Username, r.client.Password = user, pass
+	return r.client
+}
+
+// dockerPush pushes images to repoURL with the docker cli
+func (r *registryRuntime) dockerPush(repoURL string, images []string) (PushResult, error) {
+	return cliPush(r.executor, r.client, repoURL, images, r.concurrency, func(image string) Command {
+		return Command{Name: "docker", Args: []string{"push", image}}
+	})
+}
+
+// Refs lists the image references in images
+func Refs(images []PushedImage) (refs []string) {
+	for _, i := range images {
+		refs = append(refs, i.Ref)
+	}
+	return refs
+}
+
+// RegistryResult is the outcome of pushing to one registry of a multi-registry run
+type RegistryResult struct {
+	Registry string `json:"registry"`
+	PushResult
+	Error string `json:"error,omitempty"`
+}
+
+// PushResults holds the per-registry outcomes of Workflow.Push
+type PushResults []RegistryResult
+
+// Lookup finds the result for ref among pushed and unchanged images
+func (pr PushResult) Lookup(ref string) (PushedImage, bool) {
+	for _, i := range append(append([]PushedImage{}, pr.Pushed...), pr.Unchanged...) {
+		if i.Ref == ref {
+			return i, true
+		}
+	}
+	return PushedImage{}, false
+}
+
+// Lookup finds the result for ref in any registry
+func (prs PushResults) Lookup(ref string) (PushedImage, bool) {
+	for _, rr := range prs {
+		if pi, ok := rr.Lookup(ref); ok {
+			return pi, ok
+		}
+	}
+	return PushedImage{}, false
+}
+
+// WritePushResults saves prs as json for a later deploy to pin image digests
+func WritePushResults(path string, prs PushResults) error {
+	out, err := json.MarshalIndent(prs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0644)
+}
+
+func ReadPushResults(path string) (prs PushResults, err error) {
+	if err = readJSON(path, &prs); err != nil {
+		err = fmt.Errorf("push result: %v", err)
+	}
+	return prs, err
+}
+
+// cliPush pushes images with a registry CLI, skipping images the registry already holds unchanged
+func cliPush(e Executor, client *RegistryClient, repoURL string, images []string, limit int, pushCmd func(image string) Command) (result PushResult, err error) {
+	if len(images) == 0 {
+		return result, err
+	}
+	localID := localImageID(e, images[0])
+
+	return pushEach(images, limit, func(image string) (pi PushedImage, unchanged bool, err error) {
+		if remote, ok := unchangedImage(client, repoURL, image, localID); ok {
+			return remote, true, err
+		}
+
+		var res Result
+		if res, err = run(e, pushCmd(image)); err != nil {
+			return pi, false, err
+		}
+
+		pi = PushedImage{Ref: image}
+		pi.Digest, pi.Size = parsePushDigest(res.Stdout)
+		return pi, false, err
+	})
+}
+
+// pushEach runs push for every image with at most limit in flight.  Results keep image order and every
+// failure is collected into one error naming each failed image
+func pushEach(images []string, limit int, push func(image string) (pi PushedImage, unchanged bool, err error)) (result PushResult, err error) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	type outcome struct {
+		pi        PushedImage
+		unchanged bool
+		err       error
+	}
+	outcomes := make([]outcome, len(images))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, limit)
+	for i, image := range images {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, image string) {
+			defer func() { <-sem; wg.Done() }()
+
+			start := time.Now()
+			pi, unchanged, err := push(image)
+			pi.Ref, pi.Duration = image, time.Since(start)
+			outcomes[i] = outcome{pi, unchanged, err}
+		}(i, image)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, o := range outcomes {
+		switch {
+		case o.err != nil:
+			failed = append(failed, fmt.Sprintf("%v: %v", images[i], strings.TrimSpace(o.err.Error())))
+		case o.unchanged:
+			result.Unchanged = append(result.Unchanged, o.pi)
+		default:
+			result.Pushed = append(result.Pushed, o.pi)
+		}
+	}
+
+	if len(failed) == 1 {
+		err = fmt.Errorf("%v", failed[0])
+	} else if len(failed) > 1 {
+		err = fmt.Errorf("%d images failed to push:\n%v", len(failed), strings.Join(failed, "\n"))
+	}
+	return result, err
+}
+
+var pushDigestRE = regexp.MustCompile(`digest: (sha256:[0-9a-f]{64}) size: (\d+)`)
+
+// parsePushDigest extracts the manifest digest and size from `docker push` output
+func parsePushDigest(out []byte) (digest string, size int64) {
+	m := pushDigestRE.FindSubmatch(out)
+	if m == nil {
+		return digest, size
+	}
+	size, _ = strconv.ParseInt(string(m[2]), 10, 64)
+	return string(m[1]), size
+}
+
+// localImageID returns the docker image ID (config digest) of image, or "" when it cannot be determined
+// (e.g. in dryrun mode)
+func localImageID(e Executor, image string) string {
+	res, err := run(e, Command{Name: "docker", Args: []string{"image", "inspect", "--format", "{{.Id}}", image}})
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(res.Stdout))
+}
+
+// remoteImage returns the config digest and manifest details of the image at ref.  id is "" when ref does
+// not exist or is an index whose platforms cannot be compared with a single local image
+func remoteImage(c *RegistryClient, repo string, ref string) (id string, manifest Descriptor, err error) {
+	mediaType, body, err := c.GetManifest(repo, ref)
+	if err != nil || body == nil {
+		return id, manifest, err
+	}
+
+	manifest = Descriptor{MediaType: mediaType, Digest: digestOf(body), Size: int64(len(body))}
+	if mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerManifestList {
+		return id, manifest, err
+	}
+
+	var m Manifest
+	if err = json.Unmarshal(body, &m); err != nil {
+		return id, manifest, err
+	}
+	return m.Config.Digest, manifest, err
+}
+
+// unchangedImage reports whether the registry already holds image built from localID, and its remote
+// details when it does.  Lookup failures are logged and reported as changed so the push proceeds
+func unchangedImage(c *RegistryClient, repoURL string, image string, localID string) (pi PushedImage, ok bool) {
+	if c == nil || localID == "" {
+		return pi, false
+	}
+
+	_, repo := splitRepoURL(repoURL)
+	remoteID, manifest, err := remoteImage(c, repo, tagOf(repoURL, image))
+	if err != nil {
+		log.Println("digest check:", image, err)
+		return pi, false
+	}
+	if remoteID != localID {
+		return pi, false
+	}
+	return PushedImage{Ref: image, Digest: manifest.Digest, Size: manifest.Size}, true
+}
+
+// listTags lists the tags of repoURL with a client set up by Authenticate
+func listTags(c *RegistryClient, repoURL string) (tags []string, err error) {
+	if c == nil {
+		return tags, fmt.Errorf("%v: list tags before authenticate", repoURL)
+	}
+	_, repo := splitRepoURL(repoURL)
+	return c.Tags(repo)
+}
+
+// tagOf returns the tag portion of an image in repoURL, or "" when image is not a tag of repoURL
+func tagOf(repoURL string, image string) string {
+	ref, err := ParseReference(image)
+	if err != nil || ref.Repository() != repoURL {
+		return ""
+	}
+	return ref.Tag
+}
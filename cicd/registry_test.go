@@ -0,0 +1,82 @@
+package cicd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPushEach(t *testing.T) {
+	images := []string{"repo:a", "repo:b", "repo:c", "repo:d", "repo:e", "repo:f"}
+
+	for _, limit := range []int{0, 1, 3} {
+		var mu sync.Mutex
+		var inFlight, peak int
+		result, err := pushEach(images, limit, func(image string) (pi PushedImage, unchanged bool, err error) {
+			mu.Lock()
+			inFlight++
+			if inFlight > peak {
+				peak = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+
+			switch image {
+			case "repo:b":
+				return pi, true, err
+			case "repo:c", "repo:e":
+				return pi, false, fmt.Errorf("denied: %v\n", image)
+			}
+			return PushedImage{Digest: testDigest1}, false, err
+		})
+
+		want := limit
+		if want < 1 {
+			want = 1
+		}
+		if peak > want {
+			t.Errorf("limit %d: %d pushes in flight", limit, peak)
+		}
+
+		// results keep image order and every failure is named
+		if got := strings.Join(Refs(result.Pushed), ","); got != "repo:a,repo:d,repo:f" {
+			t.Errorf("limit %d: pushed %v", limit, got)
+		}
+		if got := strings.Join(Refs(result.Unchanged), ","); got != "repo:b" {
+			t.Errorf("limit %d: unchanged %v", limit, got)
+		}
+		if err == nil || err.Error() != "2 images failed to push:\nrepo:c: denied: repo:c\nrepo:e: denied: repo:e" {
+			t.Errorf("limit %d: error = %v", limit, err)
+		}
+	}
+}
+
+func TestCLIPushConcurrently(t *testing.T) {
+	const repo = "registry.example.com/team/app"
+	images := []string{repo + ":abc123", repo + ":master", repo + ":latest"}
+	recordings := []Recording{
+		dockerRecording("", "Error: No such image", "image", "inspect", "--format", "{{.Id}}", images[0]),
+		dockerRecording("abc123: digest: "+testDigest1+" size: 528\n", "", "push", images[0]),
+		dockerRecording("", "denied: requested access to the resource is denied", "push", images[1]),
+		dockerRecording("latest: digest: "+testDigest1+" size: 528\n", "", "push", images[2]),
+	}
+	e := NewReplayExecutor(recordings)
+
+	result, err := cliPush(e, nil, repo, images, 3, func(image string) Command {
+		return Command{Name: "docker", Args: []string{"push", image}}
+	})
+	if err == nil || !strings.Contains(err.Error(), images[1]+": denied") {
+		t.Errorf("cliPush error = %v", err)
+	}
+	if len(result.Pushed) != 2 || result.Pushed[0].Ref != images[0] || result.Pushed[1].Ref != images[2] || result.Pushed[1].Size != 528 {
+		t.Errorf("pushed %+v", result.Pushed)
+	}
+	if remaining := e.Remaining(); len(remaining) > 0 {
+		t.Errorf("commands not run: %v", remaining)
+	}
+}
@@ -0,0 +1,146 @@
+package cicd
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"regexp"
+	"time"
+)
+
+// Retry is a provider's retry policy for transient failures.  Attempts of 0 or 1 disables retries.
+// Backoff (default 1s) doubles after each failed attempt up to Maxbackoff (default 30s), randomized by
+// +/- Jitter (a fraction, e.g. 0.2).  Only errors whose output matches one of Patterns are retried;
+// without Patterns a default set of network and registry throttling errors is used
+type Retry struct {
+	Attempts   int
+	Backoff    string
+	Maxbackoff string
+	Jitter     float64
+	Patterns   []string
+}
+
+var defaultRetryPatterns = []string{
+	`(?i)timeout`,
+	`(?i)timed out`,
+	`(?i)connection reset`,
+	`(?i)connection refused`,
+	`(?i)tls handshake`,
+	`(?i)unexpected EOF`,
+	`(?i)too many requests`,
+	`(?i)service unavailable`,
+	`(?i)bad gateway`,
+	`(?i)internal server error`,
+	`\b(429|500|502|503|504)\b`,
+}
+
+// sleep is replaced in tests
+var sleep = time.Sleep
+
+// Validate reports negative attempts, jitter outside [0,1] and malformed durations or patterns
+func (r Retry) Validate() (err error) {
+	if r.Attempts < 0 {
+		return fmt.Errorf("retry attempts: %d is negative", r.Attempts)
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return fmt.Errorf("retry jitter: %v is not a fraction between 0 and 1", r.Jitter)
+	}
+	if _, _, err = r.durations(); err != nil {
+		return err
+	}
+	_, err = r.patterns()
+	return err
+}
+
+// Do calls fn until it succeeds, fails with a non-retryable error or attempts are exhausted
+func (r Retry) Do(op string, fn func() error) (err error) {
+	if err = r.Validate(); err != nil {
+		return err
+	}
+	backoff, maxBackoff, err := r.durations()
+	if err != nil {
+		return err
+	}
+	patterns, err := r.patterns()
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || attempt >= r.Attempts || !retryable(err, patterns) {
+			return err
+		}
+
+		delay := backoff
+		if r.Jitter > 0 {
+			delay += time.Duration(float64(delay) * r.Jitter * (2*rand.Float64() - 1))
+		}
+		log.Printf("retry: %v failed (attempt %d of %d), retrying in %v\n", op, attempt, r.Attempts, delay)
+		sleep(delay)
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (r Retry) durations() (backoff time.Duration, maxBackoff time.Duration, err error) {
+	backoff, maxBackoff = time.Second, 30*time.Second
+	if r.Backoff != "" {
+		if backoff, err = time.ParseDuration(r.Backoff); err != nil {
+			return backoff, maxBackoff, fmt.Errorf("retry backoff: %v", err)
+		}
+	}
+	if r.Maxbackoff != "" {
+		if maxBackoff, err = time.ParseDuration(r.Maxbackoff); err != nil {
+			return backoff, maxBackoff, fmt.Errorf("retry maxbackoff: %v", err)
+		}
+	}
+	return backoff, maxBackoff, err
+}
+
+func (r Retry) patterns() (res []*regexp.Regexp, err error) {
+	patterns := r.Patterns
+	if len(patterns) == 0 {
+		patterns = defaultRetryPatterns
+	}
+	for _, p := range patterns {
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("retry pattern: %v", err)
+		}
+		res = append(res, re)
+	}
+	return res, err
+}
+
+func retryable(err error, patterns []*regexp.Regexp) bool {
+	for _, re := range patterns {
+		if re.MatchString(err.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryExecutor retries commands passed to Next according to Policy
+type RetryExecutor struct {
+	Next   Executor
+	Policy Retry
+}
+
+// NewRetryExecutor wraps next with policy, returning next itself when the policy never retries
+func NewRetryExecutor(next Executor, policy Retry) Executor {
+	if policy.Attempts <= 1 {
+		return next
+	}
+	return &RetryExecutor{Next: next, Policy: policy}
+}
+
+func (e *RetryExecutor) Execute(c Command) (res Result, err error) {
+	err = e.Policy.Do(c.String(), func() (err error) {
+		res, err = e.Next.Execute(c)
+		return err
+	})
+	return res, err
+}
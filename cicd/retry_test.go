@@ -0,0 +1,108 @@
+package cicd
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordSleeps replaces sleep for the test, returning the delays slept
+func recordSleeps(t *testing.T) *[]time.Duration {
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	t.Cleanup(func() { sleep = time.Sleep })
+	return &slept
+}
+
+func TestRetryDo(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Retry
+		errs     []string
+		calls    int
+		slept    []time.Duration
+		succeeds bool
+	}{
+		{"no retries", Retry{}, []string{"503 service unavailable"}, 1, nil, false},
+		{"retried until success", Retry{Attempts: 3}, []string{"connection reset by peer", "i/o timeout", ""}, 3, []time.Duration{time.Second, 2 * time.Second}, true},
+		{"attempts exhausted", Retry{Attempts: 2, Backoff: "10ms"}, []string{"502 Bad Gateway", "502 Bad Gateway"}, 2, []time.Duration{10 * time.Millisecond}, false},
+		{"backoff capped", Retry{Attempts: 4, Backoff: "20s", Maxbackoff: "30s"}, []string{"timeout", "timeout", "timeout", "timeout"}, 4, []time.Duration{20 * time.Second, 30 * time.Second, 30 * time.Second}, false},
+		{"not retryable", Retry{Attempts: 3}, []string{"unauthorized: authentication required"}, 1, nil, false},
+		{"custom patterns", Retry{Attempts: 3, Backoff: "1ms", Patterns: []string{"(?i)denied"}}, []string{"denied", "503 service unavailable"}, 2, []time.Duration{time.Millisecond}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slept := recordSleeps(t)
+
+			var calls int
+			err := tt.policy.Do("op", func() error {
+				calls++
+				if msg := tt.errs[calls-1]; msg != "" {
+					return fmt.Errorf("%v", msg)
+				}
+				return nil
+			})
+			if (err == nil) != tt.succeeds {
+				t.Errorf("Do error = %v", err)
+			}
+			if calls != tt.calls {
+				t.Errorf("calls = %d, want %d", calls, tt.calls)
+			}
+			if !reflect.DeepEqual(*slept, tt.slept) {
+				t.Errorf("slept %v, want %v", *slept, tt.slept)
+			}
+		})
+	}
+}
+
+func TestRetryJitter(t *testing.T) {
+	slept := recordSleeps(t)
+
+	policy := Retry{Attempts: 20, Backoff: "1s", Maxbackoff: "1s", Jitter: 0.2}
+	policy.Do("op", func() error { return fmt.Errorf("timeout") })
+	for _, d := range *slept {
+		if d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Errorf("jittered delay %v outside 1s +/- 20%%", d)
+		}
+	}
+}
+
+func TestRetryValidate(t *testing.T) {
+	invalid := []Retry{
+		{Backoff: "1 second"},
+		{Maxbackoff: "soon"},
+		{Patterns: []string{"("}},
+		{Attempts: -1},
+		{Jitter: -0.1},
+		{Jitter: 1.5},
+	}
+	for _, r := range invalid {
+		if r.Validate() == nil {
+			t.Errorf("Validate(%+v) accepted an invalid policy", r)
+		}
+	}
+	for _, r := range []Retry{{}, {Attempts: 3, Backoff: "500ms", Maxbackoff: "10s", Jitter: 1, Patterns: []string{`\b503\b`}}} {
+		if err := r.Validate(); err != nil {
+			t.Errorf("Validate(%+v): %v", r, err)
+		}
+	}
+}
+
+func TestRetryExecutor(t *testing.T) {
+	slept := recordSleeps(t)
+
+	push := Command{Name: "docker", Args: []string{"push", "app:abc"}}
+	next := NewReplayExecutor([]Recording{
+		{Command: push, Error: "received unexpected HTTP status: 503 Service Unavailable"},
+		{Command: push, Stdout: "pushed"},
+	})
+	if NewRetryExecutor(next, Retry{Attempts: 1}) != Executor(next) {
+		t.Error("single attempt policy wrapped the executor")
+	}
+
+	res, err := NewRetryExecutor(next, Retry{Attempts: 3}).Execute(push)
+	if err != nil || string(res.Stdout) != "pushed" || len(*slept) != 1 {
+		t.Errorf("Execute = %q, %v after %d retries", res.Stdout, err, len(*slept))
+	}
+}
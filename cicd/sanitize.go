@@ -0,0 +1,62 @@
+package cicd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxTagLength     = 128
+	maxReleaseLength = 53
+	hashSuffixLength = 8
+)
+
+var (
+	invalidTagCharRE     = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+	invalidReleaseCharRE = regexp.MustCompile(`[^a-z0-9-]+`)
+	repeatedDashRE       = regexp.MustCompile(`-{2,}`)
+)
+
+// SanitizeTag converts s (e.g. a branch name like feature/JIRA-12_new) into a valid docker tag: runs of
+// invalid characters become "-" and leading "." and "-" are dropped.  A tag changed by sanitizing, or
+// truncated to 128 characters, ends with a hash of s, so feature/x and feature-x stay distinct.  Valid
+// tags are returned unchanged
+func SanitizeTag(s string) string {
+	tag := invalidTagCharRE.ReplaceAllString(s, "-")
+	tag = strings.TrimLeft(tag, ".-")
+	return withHash(tag, s, tag != s, maxTagLength, "")
+}
+
+// ReleaseName joins parts into a valid DNS-1123 helm release name: lowercase alphanumerics and "-",
+// starting and ending alphanumeric, at most 53 characters.  A name whose characters were replaced, or
+// which was truncated, ends with a hash of the joined parts; lowercasing and trimming "-" alone add none
+func ReleaseName(parts ...string) string {
+	raw := strings.Join(parts, "-")
+	name := invalidReleaseCharRE.ReplaceAllString(strings.ToLower(raw), "-")
+	name = strings.Trim(repeatedDashRE.ReplaceAllString(name, "-"), "-")
+	return withHash(name, raw, name != strings.Trim(strings.ToLower(raw), "-"), maxReleaseLength, "-")
+}
+
+// withHash appends a hash of the unsanitized original to s when it was changed or is over max characters,
+// shortening s to fit
+func withHash(s string, original string, changed bool, max int, trim string) string {
+	if !changed && len(s) <= max {
+		return s
+	}
+	sum := sha256.Sum256([]byte(original))
+	suffix := hex.EncodeToString(sum[:])[:hashSuffixLength]
+
+	head := s
+	if len(head) > max-hashSuffixLength-1 {
+		head = head[:max-hashSuffixLength-1]
+	}
+	if trim != "" {
+		head = strings.TrimRight(head, trim)
+	}
+	if head == "" {
+		return suffix
+	}
+	return head + "-" + suffix
+}
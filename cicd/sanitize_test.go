@@ -0,0 +1,75 @@
+package cicd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeTag(t *testing.T) {
+	long := strings.Repeat("a", 200)
+	tests := []struct {
+		in, want string
+	}{
+		{"master", "master"},
+		{"v1.2.3-rc.1", "v1.2.3-rc.1"},
+		{"feature-x", "feature-x"},
+		{"feature/x", "feature-x-" + testHash("feature/x")},
+		{"feature//JIRA-12 new", "feature-JIRA-12-new-" + testHash("feature//JIRA-12 new")},
+		{".hidden", "hidden-" + testHash(".hidden")},
+		{"-dash", "dash-" + testHash("-dash")},
+		{"///", testHash("///")},
+		{"", ""},
+		{strings.Repeat("a", 128), strings.Repeat("a", 128)},
+		{long, strings.Repeat("a", 119) + "-" + testHash(long)},
+		{"x/" + long, "x-" + strings.Repeat("a", 117) + "-" + testHash("x/"+long)},
+	}
+	for _, tt := range tests {
+		got := SanitizeTag(tt.in)
+		if got != tt.want {
+			t.Errorf("SanitizeTag(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if got != "" && (!tagRE.MatchString(got) || len(got) > maxTagLength) {
+			t.Errorf("SanitizeTag(%q) = %q is not a valid tag", tt.in, got)
+		}
+		if again := SanitizeTag(got); again != got {
+			t.Errorf("SanitizeTag(%q) = %q, not stable", got, again)
+		}
+	}
+
+	if SanitizeTag("feature/x") == SanitizeTag("feature-x") {
+		t.Error("feature/x and feature-x share a tag")
+	}
+}
+
+func TestReleaseName(t *testing.T) {
+	long := strings.Repeat("b", 60)
+	tests := []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"app", "master"}, "app-master"},
+		{[]string{"App", "Master"}, "app-master"},
+		{[]string{"app", ""}, "app"},
+		{[]string{"app", "feature/x"}, "app-feature-x-" + testHash("app-feature/x")},
+		{[]string{"app", "feature_x"}, "app-feature-x-" + testHash("app-feature_x")},
+		{[]string{"app", "-x-"}, "app-x-" + testHash("app--x-")},
+		{[]string{"app", "///"}, "app-" + testHash("app-///")},
+		{[]string{"", "///"}, testHash("-///")},
+		{[]string{"app", long}, "app-" + strings.Repeat("b", 40) + "-" + testHash("app-"+long)},
+		{[]string{"app", strings.Repeat("b", 39) + "-" + long}, "app-" + strings.Repeat("b", 39) + "-" + testHash("app-"+strings.Repeat("b", 39)+"-"+long)},
+	}
+	for _, tt := range tests {
+		got := ReleaseName(tt.parts...)
+		if got != tt.want {
+			t.Errorf("ReleaseName(%q) = %q, want %q", tt.parts, got, tt.want)
+		}
+		if len(got) > maxReleaseLength || strings.HasPrefix(got, "-") || strings.HasSuffix(got, "-") || strings.Contains(got, "--") {
+			t.Errorf("ReleaseName(%q) = %q is not a valid release name", tt.parts, got)
+		}
+	}
+}
+
+// testHash is the hash suffix for s
+func testHash(s string) string {
+	return withHash("", s, true, maxTagLength, "")
+}
@@ -0,0 +1,116 @@
+package cicd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Semver is a semantic version as given by a release git tag, e.g. v1.4.2 or 2.0.0-rc.1
+type Semver struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Build      string
+}
+
+var semverRE = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z.-]+))?(?:\+([0-9A-Za-z.-]+))?$`)
+
+// ParseSemver parses s, which may carry a leading "v"
+func ParseSemver(s string) (v Semver, err error) {
+	m := semverRE.FindStringSubmatch(s)
+	if m == nil {
+		return v, fmt.Errorf("invalid semantic version %q: must be [v]MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD]", s)
+	}
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	v.Patch, _ = strconv.Atoi(m[3])
+	v.Prerelease, v.Build = m[4], m[5]
+	return v, err
+}
+
+func (v Semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Less orders versions by semver precedence: major, minor and patch, with a prerelease before its release.
+// Prerelease identifiers compare numerically when both are numeric and as strings otherwise, numeric
+// first, and a shorter list of equal identifiers comes first.  Build metadata is ignored
+func (v Semver) Less(o Semver) bool {
+	switch {
+	case v.Major != o.Major:
+		return v.Major < o.Major
+	case v.Minor != o.Minor:
+		return v.Minor < o.Minor
+	case v.Patch != o.Patch:
+		return v.Patch < o.Patch
+	case v.Prerelease == o.Prerelease:
+		return false
+	case v.Prerelease == "":
+		return false
+	case o.Prerelease == "":
+		return true
+	}
+
+	a, b := strings.Split(v.Prerelease, "."), strings.Split(o.Prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		an, aerr := strconv.ParseUint(a[i], 10, 64)
+		bn, berr := strconv.ParseUint(b[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil:
+			return an < bn
+		case aerr == nil || berr == nil:
+			return aerr == nil
+		}
+		return a[i] < b[i]
+	}
+	return len(a) < len(b)
+}
+
+// floatingTags returns the tags a release of v moves along with it: MAJOR.MINOR, MAJOR and, when latest
+// is set, latest.  Prereleases move no floating tags
+func (v Semver) floatingTags(latest bool) (tags []string) {
+	if v.Prerelease != "" {
+		return tags
+	}
+	tags = []string{fmt.Sprintf("%d.%d", v.Major, v.Minor), strconv.Itoa(v.Major)}
+	if latest {
+		tags = append(tags, "latest")
+	}
+	return tags
+}
+
+// newerRelease returns the highest release in tags that would lose floating tag to v, if any
+func (v Semver) newerRelease(tag string, tags []string) (newer Semver, ok bool) {
+	for _, t := range tags {
+		o, err := ParseSemver(t)
+		if err != nil || o.Prerelease != "" || !v.Less(o) {
+			continue
+		}
+		switch {
+		case tag == "latest":
+		case tag == strconv.Itoa(v.Major):
+			if o.Major != v.Major {
+				continue
+			}
+		case o.Major != v.Major || o.Minor != v.Minor:
+			continue
+		}
+		if !ok || newer.Less(o) {
+			newer, ok = o, true
+		}
+	}
+	return newer, ok
+}
@@ -0,0 +1,100 @@
+package cicd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Semver
+	}{
+		{"1.4.2", Semver{Major: 1, Minor: 4, Patch: 2}},
+		{"v0.10.0", Semver{Minor: 10}},
+		{"v2.0.0-rc.1", Semver{Major: 2, Prerelease: "rc.1"}},
+		{"1.0.0-alpha-1+build.5", Semver{Major: 1, Prerelease: "alpha-1", Build: "build.5"}},
+	}
+	for _, tt := range tests {
+		v, err := ParseSemver(tt.in)
+		if err != nil || v != tt.want {
+			t.Errorf("ParseSemver(%q) = %+v, %v, want %+v", tt.in, v, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "1.4", "v1.4.2.1", "01.4.2", "1.4.2-", "V1.4.2", "release-1.4.2"} {
+		if _, err := ParseSemver(s); err == nil {
+			t.Errorf("ParseSemver(%q) accepted an invalid version", s)
+		}
+	}
+}
+
+func TestSemverLess(t *testing.T) {
+	// each version is lower than the next, in semver precedence
+	ordered := []string{
+		"0.9.9", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0-rc.2", "1.0.0-rc.10", "1.0.0", "1.0.1", "1.2.0", "1.10.0", "2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := ParseSemver(ordered[i])
+			b, _ := ParseSemver(ordered[j])
+			if got := a.Less(b); got != (i < j) {
+				t.Errorf("%v.Less(%v) = %v", a, b, got)
+			}
+		}
+	}
+
+	a, _ := ParseSemver("1.0.0+build.1")
+	b, _ := ParseSemver("1.0.0+build.2")
+	if a.Less(b) || b.Less(a) {
+		t.Error("build metadata affects precedence")
+	}
+}
+
+func TestSemverFloatingTags(t *testing.T) {
+	tests := []struct {
+		version string
+		latest  bool
+		want    []string
+	}{
+		{"1.4.2", false, []string{"1.4", "1"}},
+		{"1.4.2", true, []string{"1.4", "1", "latest"}},
+		{"2.0.0-rc.1", true, nil},
+	}
+	for _, tt := range tests {
+		v, _ := ParseSemver(tt.version)
+		if got := v.floatingTags(tt.latest); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v floating tags = %q, want %q", v, got, tt.want)
+		}
+	}
+}
+
+func TestSemverNewerRelease(t *testing.T) {
+	tests := []struct {
+		name, version, tag string
+		existing           []string
+		want               string
+	}{
+		{"patch of an older minor keeps major", "1.3.5", "1", []string{"1.3.4", "1.4.0", "1.4", "1", "latest"}, "1.4.0"},
+		{"patch of an older minor keeps latest", "1.3.5", "latest", []string{"1.3.4", "1.4.0"}, "1.4.0"},
+		{"patch of an older minor moves its minor", "1.3.5", "1.3", []string{"1.3.4", "1.4.0"}, ""},
+		{"newest release moves every tag", "1.4.1", "1", []string{"1.3.4", "1.4.0"}, ""},
+		{"other majors do not hold the major tag", "1.4.1", "1", []string{"2.0.0"}, ""},
+		{"other majors hold latest", "1.4.1", "latest", []string{"2.0.0", "2.1.0"}, "2.1.0"},
+		{"prereleases ignored", "1.4.1", "latest", []string{"1.5.0-rc.1", "2.0.0-beta"}, ""},
+		{"v prefixed tags", "1.3.5", "1", []string{"v1.4.0"}, "1.4.0"},
+		{"same release", "1.4.0", "1.4", []string{"1.4.0"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, _ := ParseSemver(tt.version)
+			newer, ok := v.newerRelease(tt.tag, tt.existing)
+			switch {
+			case tt.want == "" && ok:
+				t.Errorf("tag %v held by %v", tt.tag, newer)
+			case tt.want != "" && (!ok || newer.String() != tt.want):
+				t.Errorf("tag %v held by %v, %v, want %v", tt.tag, newer, ok, tt.want)
+			}
+		})
+	}
+}
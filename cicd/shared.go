@@ -3,24 +3,75 @@ package cicd
 import (
 	"log"
 	"strings"
-
-	"github.com/spf13/viper"
 )
 
-func IsDryRun() bool {
-	return viper.GetBool("isDryRun")
+// Options holds the runtime settings for a workflow run.  The cobra commands populate it from flags;
+// other Go programs may set it directly before calling Workflow.Push or Workflow.Deploy
+type Options struct {
+	Debug  bool
+	DryRun bool
+
+	// push
+	Image      string
+	Event      string
+	PR         string
+	Source     string
+	ResultFile string
+
+	// push images built from a dirty git working tree
+	Force bool
+
+	// multi-architecture push: <os>/<arch>[/<variant>]=<image or source> for each platform
+	Platforms []string
+
+	// build: KEY=VALUE build args overriding those in cicd.yaml
+	BuildArgs []string
+
+	// tag template variables
+	Commit  string
+	Build   string
+	Version string
+
+	// release events: also move the latest tag
+	Latest bool
+
+	// push and deploy
+	Branch string
+
+	// deploy
+	Tag       string
+	Repo      string
+	Service   string
+	Namespace string
+	Chart     string
+	Template  string
+
+	// deploy image pinning: an explicit digest, or the push result file to find it in
+	Digest     string
+	PushResult string
+
+	// promote: registry ids to copy the image at Tag or Digest between, and environment tags to apply.
+	// PromoteRepo replaces the repository of the PromoteTo registry with another on the same host
+	PromoteFrom string
+	PromoteTo   string
+	PromoteRepo string
+	EnvTags     []string
 }
 
-func IsDebug() bool {
-	return viper.GetBool("isDebug")
+func (wf *Workflow) IsDryRun() bool {
+	return wf.Options.DryRun
 }
 
-func LogError(err error) {
-	log.Printf("error: %v\n", strings.TrimSpace(err.Error()))
+func (wf *Workflow) IsDebug() bool {
+	return wf.Options.Debug
 }
 
-func LogDebug(s string) {
-	if IsDebug() {
+func (wf *Workflow) LogDebug(s string) {
+	if wf.IsDebug() {
 		log.Printf("debug: %v\n", strings.TrimSpace(s))
 	}
 }
+
+func LogError(err error) {
+	log.Printf("error: %v\n", strings.TrimSpace(err.Error()))
+}
@@ -0,0 +1,370 @@
+package cicd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// Signing configures cosign-compatible image signatures.  Key is a PEM private key file (ECDSA, RSA or
+// Ed25519, unencrypted PKCS#8, SEC1 or PKCS#1) used by push and promote to sign every digest they store.
+// Keys are PEM public key files; when set, deploy refuses an image without a signature for its repository
+// verifying against one of them, and deploys it by the verified digest
+type Signing struct {
+	Key  string
+	Keys []string
+}
+
+const (
+	MediaTypeCosignPayload = "application/vnd.dev.cosign.simplesigning.v1+json"
+
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	cosignSignatureType       = "cosign container image signature"
+)
+
+// simpleSigning is the signed payload, naming the repository and manifest digest it vouches for
+type simpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// signatureTag is where cosign stores the signatures of digest: sha256:<hex> becomes sha256-<hex>.sig
+func signatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// signPushed signs each distinct digest pushed to the registry
+func (wf *Workflow) signPushed(ar Registrator, result PushResult) (err error) {
+	if wf.App.Signing.Key == "" {
+		return err
+	}
+
+	var digests []string
+	for _, pi := range append(append([]PushedImage{}, result.Pushed...), result.Unchanged...) {
+		if pi.Digest == "" {
+			if wf.IsDryRun() {
+				continue
+			}
+			return fmt.Errorf("sign %v: pushed digest unknown", pi.Ref)
+		}
+		if !contains(digests, pi.Digest) {
+			digests = append(digests, pi.Digest)
+		}
+	}
+
+	repoURL := ar.GetRepoURL()
+	if wf.IsDryRun() {
+		log.Println("dryrun: sign", repoURL, digests)
+		return err
+	}
+
+	signer, err := loadPrivateKey(wf.App.Signing.Key)
+	if err != nil {
+		return err
+	}
+	api, ok := ar.(RegistryAPI)
+	if !ok || api.Client() == nil {
+		return fmt.Errorf("%v: registry api unavailable to store signatures", repoURL)
+	}
+
+	for _, digest := range digests {
+		if err = signImage(api.Client(), repoURL, digest, signer); err != nil {
+			return fmt.Errorf("sign %v@%v: %v", repoURL, digest, err)
+		}
+	}
+	return err
+}
+
+// signImage adds a signature of digest to the signature manifest stored alongside it, unless the key has
+// signed it already
+func signImage(c *RegistryClient, repoURL string, digest string, signer crypto.Signer) (err error) {
+	ref, err := ParseRepository(repoURL)
+	if err != nil {
+		return err
+	}
+	_, repo := splitRepoURL(repoURL)
+
+	var payload simpleSigning
+	payload.Critical.Identity.DockerReference = ref.Repository()
+	payload.Critical.Image.DockerManifestDigest = digest
+	payload.Critical.Type = cosignSignatureType
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	// existing signatures are kept; a signature by the same key is not repeated
+	var m Manifest
+	if m, err = signatureManifest(c, repo, digest); err != nil {
+		return err
+	}
+	for _, l := range m.Layers {
+		if l.Digest != digestOf(body) {
+			continue
+		}
+		if sig, derr := base64.StdEncoding.DecodeString(l.Annotations[cosignSignatureAnnotation]); derr == nil && verifySignature(signer.Public(), body, sig) == nil {
+			log.Println("already signed:", repoURL+"@"+digest)
+			return err
+		}
+	}
+
+	sig, err := sign(signer, body)
+	if err != nil {
+		return err
+	}
+	layer := Descriptor{
+		MediaType:   MediaTypeCosignPayload,
+		Digest:      digestOf(body),
+		Size:        int64(len(body)),
+		Annotations: map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+	}
+	m.Layers = append(m.Layers, layer)
+
+	// the config lists each signature payload as an image layer
+	var config struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+		RootFS       struct {
+			Type    string   `json:"type"`
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+		Config struct{} `json:"config"`
+	}
+	config.RootFS.Type = "layers"
+	for _, l := range m.Layers {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, l.Digest)
+	}
+	configBody, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	m.SchemaVersion = 2
+	m.MediaType = MediaTypeOCIManifest
+	m.Config = Descriptor{MediaType: MediaTypeOCIConfig, Digest: digestOf(configBody), Size: int64(len(configBody))}
+
+	for _, blob := range [][]byte{body, configBody} {
+		d := Descriptor{Digest: digestOf(blob), Size: int64(len(blob))}
+		var exists bool
+		if exists, err = c.BlobExists(repo, d); err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		b := blob
+		if err = c.UploadBlob(repo, d, func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(b)), nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	var manifest []byte
+	if manifest, err = json.Marshal(m); err != nil {
+		return err
+	}
+	if _, err = c.PutManifest(repo, signatureTag(digest), MediaTypeOCIManifest, manifest); err != nil {
+		return err
+	}
+	log.Println("signed image:", repoURL+"@"+digest)
+	return err
+}
+
+// signatureManifest returns the signature manifest of digest, empty when the image is unsigned
+func signatureManifest(c *RegistryClient, repo string, digest string) (m Manifest, err error) {
+	_, body, err := c.GetManifest(repo, signatureTag(digest))
+	if err != nil || body == nil {
+		return m, err
+	}
+	if err = json.Unmarshal(body, &m); err != nil {
+		return m, fmt.Errorf("signature manifest: %v", err)
+	}
+	return m, err
+}
+
+// verifyImage checks that digest carries a signature verifying against one of the public key files
+func verifyImage(c *RegistryClient, repoURL string, digest string, keyFiles []string) (err error) {
+	var keys []crypto.PublicKey
+	for _, f := range keyFiles {
+		var key crypto.PublicKey
+		if key, err = loadPublicKey(f); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	ref, err := ParseRepository(repoURL)
+	if err != nil {
+		return err
+	}
+	_, repo := splitRepoURL(repoURL)
+	m, err := signatureManifest(c, repo, digest)
+	if err != nil {
+		return err
+	}
+	if len(m.Layers) == 0 {
+		return fmt.Errorf("%v@%v is not signed", repoURL, digest)
+	}
+
+	// signatures vouch for the digest in a named repository; those made for another are not accepted
+	var others []string
+	for _, l := range m.Layers {
+		if l.MediaType != MediaTypeCosignPayload {
+			continue
+		}
+		sig, derr := base64.StdEncoding.DecodeString(l.Annotations[cosignSignatureAnnotation])
+		if derr != nil {
+			continue
+		}
+
+		var body []byte
+		if body, err = readBlob(c, repo, l); err != nil {
+			return err
+		}
+		var payload simpleSigning
+		if json.Unmarshal(body, &payload) != nil || payload.Critical.Image.DockerManifestDigest != digest {
+			continue
+		}
+		if identity := payload.Critical.Identity.DockerReference; !sameRepository(identity, ref) {
+			others = append(others, identity)
+			continue
+		}
+
+		for _, key := range keys {
+			if verifySignature(key, body, sig) == nil {
+				log.Println("verified signature:", repoURL+"@"+digest)
+				return nil
+			}
+		}
+	}
+	if len(others) > 0 {
+		return fmt.Errorf("%v@%v has no signature for its repository verifying against the configured public keys; signed for %v", repoURL, digest, strings.Join(others, ", "))
+	}
+	return fmt.Errorf("%v@%v has no signature verifying against the configured public keys", repoURL, digest)
+}
+
+// sameRepository reports whether the signed identity names the repository ref, however either is written
+func sameRepository(identity string, ref Reference) bool {
+	id, err := ParseRepository(identity)
+	return err == nil && id.APIHost() == ref.APIHost() && id.APIPath() == ref.APIPath()
+}
+
+// readBlob fetches a small blob and checks it against its digest
+func readBlob(c *RegistryClient, repo string, d Descriptor) (b []byte, err error) {
+	rc, err := c.GetBlob(repo, d.Digest)
+	if err != nil {
+		return b, err
+	}
+	defer rc.Close()
+
+	if b, err = ioutil.ReadAll(io.LimitReader(rc, 1<<20)); err != nil {
+		return b, err
+	}
+	if digestOf(b) != d.Digest {
+		return nil, fmt.Errorf("blob %v: content does not match digest", d.Digest)
+	}
+	return b, err
+}
+
+func sign(signer crypto.Signer, payload []byte) ([]byte, error) {
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		return signer.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	sum := sha256.Sum256(payload)
+	return signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+}
+
+func verifySignature(key crypto.PublicKey, payload []byte, sig []byte) error {
+	sum := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(k, sum[:], sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig)
+	case ed25519.PublicKey:
+		if ed25519.Verify(k, payload, sig) {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	return fmt.Errorf("%v", "signature mismatch")
+}
+
+func loadPrivateKey(path string) (signer crypto.Signer, err error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return signer, err
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "ENCRYPTED COSIGN PRIVATE KEY", "ENCRYPTED SIGSTORE PRIVATE KEY":
+		return signer, fmt.Errorf("signing key %v: encrypted cosign keys are not supported; use an unencrypted PKCS#8 key", path)
+	default:
+		return signer, fmt.Errorf("signing key %v: unsupported PEM type %q", path, block.Type)
+	}
+	if err != nil {
+		return signer, fmt.Errorf("signing key %v: %v", path, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return signer, fmt.Errorf("signing key %v: unsupported key type %T", path, key)
+	}
+	return signer, err
+}
+
+func loadPublicKey(path string) (key crypto.PublicKey, err error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return key, err
+	}
+	if block.Type != "PUBLIC KEY" {
+		return key, fmt.Errorf("public key %v: unsupported PEM type %q", path, block.Type)
+	}
+	if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return key, fmt.Errorf("public key %v: %v", path, err)
+	}
+	return key, err
+}
+
+func readPEM(path string) (block *pem.Block, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return block, err
+	}
+	if block, _ = pem.Decode(b); block == nil {
+		return block, fmt.Errorf("%v: no PEM data", path)
+	}
+	return block, err
+}
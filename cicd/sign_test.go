@@ -0,0 +1,153 @@
+package cicd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestKeys writes a new ECDSA key pair as PEM files, returning their paths
+func writeTestKeys(t *testing.T) (private string, public string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private, public = filepath.Join(dir, "cosign.key"), filepath.Join(dir, "cosign.pub")
+	writeTestFile(t, private, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})))
+	writeTestFile(t, public, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})))
+	return private, public
+}
+
+// signTestImage stores an image tagged v1 in repo of reg and signs it for that repository
+func signTestImage(t *testing.T, reg *testRegistry, repo string, private string) (digest string) {
+	t.Helper()
+	digest = reg.PutImage(repo, "2024-01-01T00:00:00Z", "v1").Digest
+
+	signer, err := loadPrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = signImage(reg.Client(), reg.Host()+"/"+repo, digest, signer); err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+func TestVerifyImage(t *testing.T) {
+	reg := newTestRegistry(t)
+	private, public := writeTestKeys(t)
+	_, otherPublic := writeTestKeys(t)
+	digest := signTestImage(t, reg, "team/app", private)
+
+	// the signature manifest copied to another repository still names team/app
+	c := reg.Client()
+	_, body, err := c.GetManifest("team/app", signatureTag(digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.PutManifest("team/copy", signatureTag(digest), MediaTypeOCIManifest, body); err != nil {
+		t.Fatal(err)
+	}
+	reg.PutImage("team/unsigned", "2024-01-01T00:00:00Z", "v1")
+
+	tests := []struct {
+		name, repo string
+		keys       []string
+		err        string
+	}{
+		{"signed", "team/app", []string{public}, ""},
+		{"any of the keys", "team/app", []string{otherPublic, public}, ""},
+		{"other key", "team/app", []string{otherPublic}, "no signature verifying"},
+		{"signed for another repository", "team/copy", []string{public}, "signed for " + reg.Host() + "/team/app"},
+		{"unsigned", "team/unsigned", []string{public}, "is not signed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyImage(c, reg.Host()+"/"+tt.repo, digest, tt.keys)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatal(err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("verifyImage error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSameRepository(t *testing.T) {
+	ref, _ := ParseRepository("docker.io/library/app")
+	for identity, want := range map[string]bool{
+		"app":                       true,
+		"docker.io/library/app":     true,
+		"index.docker.io/app":       true,
+		"docker.io/team/app":        false,
+		"registry.example.com/app":  false,
+		"not a repository":          false,
+		"docker.io/library/app:tag": false,
+	} {
+		if got := sameRepository(identity, ref); got != want {
+			t.Errorf("sameRepository(%q) = %v, want %v", identity, got, want)
+		}
+	}
+}
+
+func TestDeployVerifiesSignature(t *testing.T) {
+	reg := newTestRegistry(t)
+	private, public := writeTestKeys(t)
+	digest := signTestImage(t, reg, "team/app", private)
+
+	tests := []struct {
+		name, template, repo string
+		noDigestHeader       bool
+		err                  string
+	}{
+		{"image template", "image: {{.Image}}\n", "", false, ""},
+		{"registry without digest header", "image: {{.Image}}\n", "", true, ""},
+		{"tag template", "image: {{.Repo}}:{{.Tag}}\n", "", false, "unpinned"},
+		{"repository outside the registry", "image: {{.Image}}\n", "registry.example.com/team/app", false, "not in the active registry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg.NoDigestHeader = tt.noDigestHeader
+			wf, values := newDeployWorkflow(t, tt.template)
+			wf.Config.Provider.Registry.ID = "oci"
+			wf.Provider.Registry.OCI.Url = reg.Host() + "/team/app"
+			wf.Provider.Registry.OCI.Insecure = true
+			wf.App.Signing.Keys = []string{public}
+			wf.SetExecutor(NewReplayExecutor(deployRecordings(wf, "main")))
+			wf.Options = Options{Branch: "main", Tag: "v1", Repo: tt.repo}
+
+			err := wf.Deploy()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Deploy error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := ioutil.ReadFile(values); !strings.Contains(string(got), "@"+digest) {
+				t.Errorf("values = %q, want the verified digest %v", got, digest)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package cicd
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TagRule is one entry of the tag policy in cicd.yaml.  Template is a text/template rendered with TagVars;
+// the rule applies only when the build event is listed in Events and the branch matches one of the
+// Branches glob patterns (either list empty means any).  Rules rendering an empty tag are skipped
+type TagRule struct {
+	Template string
+	Events   []string
+	Branches []string
+}
+
+// TagVars are the variables available to tag templates
+type TagVars struct {
+	Branch      string
+	Commit      string
+	ShortCommit string
+	Build       string
+	PR          string
+	Event       string
+	Date        string
+	Timestamp   string
+	Semver      string
+
+	// SemverMinor (e.g. 1.4) and SemverMajor (e.g. 1) are empty for prereleases and unparsable versions
+	SemverMinor string
+	SemverMajor string
+
+	// Latest is set by --latest, except for prereleases
+	Latest bool
+
+	// ImageTag is the tag of the --image base image
+	ImageTag string
+}
+
+// releaseEvents publish semantic version tags from the --version git tag
+var releaseEvents = []string{"tag", "release"}
+
+// defaultTagRules reproduce the original scheme: commit tag, branch tag, latest on master and PR-<n>.
+// Releases publish MAJOR.MINOR.PATCH, MAJOR.MINOR, MAJOR and latest when requested
+var defaultTagRules = []TagRule{
+	{Template: "{{.ImageTag}}"},
+	{Template: "{{.Branch}}", Events: []string{"push"}},
+	{Template: "latest", Events: []string{"push"}, Branches: []string{"master"}},
+	{Template: "PR-{{.PR}}", Events: []string{"pull_request"}},
+	{Template: "{{.Semver}}", Events: releaseEvents},
+	{Template: "{{.SemverMinor}}", Events: releaseEvents},
+	{Template: "{{.SemverMajor}}", Events: releaseEvents},
+	{Template: "{{if .Latest}}latest{{end}}", Events: releaseEvents},
+}
+
+// tagVars collects template variables from the runtime options
+func tagVars(opts Options, imageTag string) TagVars {
+	now := time.Now().UTC()
+
+	commit := opts.Commit
+	if commit == "" {
+		commit = imageTag
+	}
+	short := commit
+	if len(short) > 7 {
+		short = short[:7]
+	}
+
+	vars := TagVars{
+		Branch:      opts.Branch,
+		Commit:      commit,
+		ShortCommit: short,
+		Build:       opts.Build,
+		PR:          opts.PR,
+		Event:       opts.Event,
+		Date:        now.Format("20060102"),
+		Timestamp:   now.Format("20060102T150405Z"),
+		Semver:      strings.TrimPrefix(opts.Version, "v"),
+		ImageTag:    imageTag,
+	}
+	if v, err := ParseSemver(opts.Version); err == nil {
+		// build metadata is not part of the version and "+" is not valid in a docker tag
+		v.Build = ""
+		vars.Semver = v.String()
+		if v.Prerelease == "" {
+			vars.SemverMinor = fmt.Sprintf("%d.%d", v.Major, v.Minor)
+			vars.SemverMajor = fmt.Sprint(v.Major)
+			vars.Latest = opts.Latest
+		}
+	}
+	return vars
+}
+
+// renderTags applies rules to vars, returning the distinct sanitized tags in rule order.  Distinct rendered
+// tags that sanitize to the same docker tag are reported as a collision rather than silently merged
+func renderTags(rules []TagRule, vars TagVars) (tags []string, err error) {
+	seen := map[string]string{}
+
+	for i, rule := range rules {
+		if !rule.matches(vars) {
+			continue
+		}
+
+		var t *template.Template
+		if t, err = template.New(fmt.Sprintf("tag%d", i)).Option("missingkey=error").Parse(rule.Template); err != nil {
+			return nil, fmt.Errorf("tag template %q: %v", rule.Template, err)
+		}
+
+		var out bytes.Buffer
+		if err = t.Execute(&out, vars); err != nil {
+			return nil, fmt.Errorf("tag template %q: %v", rule.Template, err)
+		}
+
+		raw := strings.TrimSpace(out.String())
+		if raw == "" {
+			continue
+		}
+
+		tag := SanitizeTag(raw)
+		if prev, ok := seen[tag]; ok {
+			if prev != raw {
+				return nil, fmt.Errorf("tag collision: %q and %q both sanitize to %q", prev, raw, tag)
+			}
+			continue
+		}
+		seen[tag] = raw
+		tags = append(tags, tag)
+	}
+	return tags, err
+}
+
+func (rule TagRule) matches(vars TagVars) bool {
+	if len(rule.Events) > 0 && !contains(rule.Events, vars.Event) {
+		return false
+	}
+	if len(rule.Branches) == 0 {
+		return true
+	}
+	for _, pattern := range rule.Branches {
+		if ok, _ := path.Match(pattern, vars.Branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
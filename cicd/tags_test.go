@@ -0,0 +1,71 @@
+package cicd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderTagsDefaultRules(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"push master", Options{Event: "push", Branch: "master"}, []string{"abc123", "master", "latest"}},
+		{"push branch", Options{Event: "push", Branch: "feature/x"}, []string{"abc123", SanitizeTag("feature/x")}},
+		{"pull request", Options{Event: "pull_request", Branch: "feature/x", PR: "12"}, []string{"abc123", "PR-12"}},
+		{"release", Options{Event: "tag", Version: "v1.4.2"}, []string{"abc123", "1.4.2", "1.4", "1"}},
+		{"latest release", Options{Event: "release", Version: "v1.4.2", Latest: true}, []string{"abc123", "1.4.2", "1.4", "1", "latest"}},
+		{"prerelease", Options{Event: "tag", Version: "v2.0.0-rc.1", Latest: true}, []string{"abc123", "2.0.0-rc.1"}},
+		{"release with build metadata", Options{Event: "tag", Version: "v1.4.2+build.7"}, []string{"abc123", "1.4.2", "1.4", "1"}},
+		{"prerelease with build metadata", Options{Event: "tag", Version: "v2.0.0-rc.1+sha.abc"}, []string{"abc123", "2.0.0-rc.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, err := renderTags(defaultTagRules, tagVars(tt.opts, "abc123"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tags, tt.want) {
+				t.Errorf("tags = %q, want %q", tags, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTagsRules(t *testing.T) {
+	vars := tagVars(Options{Event: "push", Branch: "release/1.4", Commit: "0123456789abcdef", Build: "42"}, "abc123")
+
+	tests := []struct {
+		name  string
+		rules []TagRule
+		want  []string
+		err   string
+	}{
+		{"template variables", []TagRule{{Template: "{{.ShortCommit}}-{{.Build}}"}, {Template: "{{.Commit}}"}}, []string{"0123456-42", "0123456789abcdef"}, ""},
+		{"event filter", []TagRule{{Template: "push-{{.Build}}", Events: []string{"push"}}, {Template: "pr-{{.PR}}", Events: []string{"pull_request"}}}, []string{"push-42"}, ""},
+		{"branch glob", []TagRule{{Template: "rc", Branches: []string{"release/*"}}, {Template: "main", Branches: []string{"main", "master"}}}, []string{"rc"}, ""},
+		{"glob does not cross /", []TagRule{{Template: "rc", Branches: []string{"*"}}}, nil, ""},
+		{"empty tags skipped", []TagRule{{Template: "{{.PR}}"}, {Template: " "}, {Template: "b{{.Build}}"}}, []string{"b42"}, ""},
+		{"duplicates merged", []TagRule{{Template: "{{.Build}}"}, {Template: "42"}}, []string{"42"}, ""},
+		{"sanitized", []TagRule{{Template: "{{.Branch}}"}}, []string{SanitizeTag("release/1.4")}, ""},
+		{"unknown variable", []TagRule{{Template: "{{.Nope}}"}}, nil, "can't evaluate field Nope"},
+		{"parse error", []TagRule{{Template: "{{.Build"}}, nil, "tag template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, err := renderTags(tt.rules, vars)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("renderTags error = %v, want %q", err, tt.err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case !reflect.DeepEqual(tags, tt.want):
+				t.Errorf("tags = %q, want %q", tags, tt.want)
+			}
+		})
+	}
+}
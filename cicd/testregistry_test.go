@@ -0,0 +1,274 @@
+package cicd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testRegistry is an in-process stand-in for a distribution API registry.  Manifests are stored per
+// repository by tag and by digest; with Token set, requests must present a bearer token obtained from
+// its /token realm.  With NoDigestHeader set, manifest reads omit Docker-Content-Digest as some
+// registries and proxies do
+type testRegistry struct {
+	Token          string
+	PageSize       int
+	NoDigestHeader bool
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string]map[string][]byte
+	types     map[string]string
+	uploads   int
+	requests  []string
+	failures  map[string]int
+
+	server *httptest.Server
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	reg := &testRegistry{
+		blobs:     map[string][]byte{},
+		manifests: map[string]map[string][]byte{},
+		types:     map[string]string{},
+		failures:  map[string]int{},
+	}
+	reg.server = httptest.NewServer(reg)
+	t.Cleanup(reg.server.Close)
+	return reg
+}
+
+// Host is the host:port of the registry
+func (reg *testRegistry) Host() string {
+	return strings.TrimPrefix(reg.server.URL, "http://")
+}
+
+// Client returns a client for the registry
+func (reg *testRegistry) Client() *RegistryClient {
+	c := NewRegistryClient(reg.Host())
+	c.Insecure = true
+	return c
+}
+
+// FailNext answers the next n requests whose path contains substr with 503
+func (reg *testRegistry) FailNext(substr string, n int) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.failures[substr] = n
+}
+
+// Requests returns "METHOD path" for every request served
+func (reg *testRegistry) Requests() []string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return append([]string{}, reg.requests...)
+}
+
+// Manifest returns the manifest stored in repo at ref, nil when absent
+func (reg *testRegistry) Manifest(repo string, ref string) []byte {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.manifests[repo][ref]
+}
+
+// PutBlob stores b, returning its descriptor
+func (reg *testRegistry) PutBlob(mediaType string, b []byte) Descriptor {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.blobs[digestOf(b)] = b
+	return Descriptor{MediaType: mediaType, Digest: digestOf(b), Size: int64(len(b))}
+}
+
+// PutManifest stores v as the manifest of repo at each ref and by digest, returning its descriptor
+func (reg *testRegistry) PutManifest(repo string, mediaType string, v interface{}, refs ...string) Descriptor {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.storeManifest(repo, mediaType, b, refs...)
+	return Descriptor{MediaType: mediaType, Digest: digestOf(b), Size: int64(len(b))}
+}
+
+// PutImage stores a single layer image whose config records created, tagged with refs
+func (reg *testRegistry) PutImage(repo string, created string, refs ...string) Descriptor {
+	config := reg.PutBlob(MediaTypeOCIConfig, []byte(fmt.Sprintf(`{"created":%q,"architecture":"amd64","os":"linux"}`, created)))
+	layer := reg.PutBlob(MediaTypeOCILayer, []byte("layer "+created))
+	return reg.PutManifest(repo, MediaTypeOCIManifest, Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIManifest, Config: config, Layers: []Descriptor{layer}}, refs...)
+}
+
+func (reg *testRegistry) storeManifest(repo string, mediaType string, b []byte, refs ...string) {
+	if reg.manifests[repo] == nil {
+		reg.manifests[repo] = map[string][]byte{}
+	}
+	for _, ref := range append(refs, digestOf(b)) {
+		reg.manifests[repo][ref] = b
+	}
+	reg.types[digestOf(b)] = mediaType
+}
+
+func (reg *testRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.requests = append(reg.requests, r.Method+" "+r.URL.Path)
+	for substr, n := range reg.failures {
+		if n > 0 && strings.Contains(r.URL.Path, substr) {
+			reg.failures[substr]--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	if r.URL.Path == "/token" {
+		if !strings.HasPrefix(r.URL.Query().Get("scope"), "repository:") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": reg.Token})
+		return
+	}
+	if reg.Token != "" && r.Header.Get("Authorization") != "Bearer "+reg.Token {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+r.Host+`/token",service="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	p := r.URL.Path
+	switch {
+	case p == "/v2/":
+	case strings.HasSuffix(p, "/blobs/uploads/") && r.Method == "POST":
+		w.Header().Set("Location", p+"session")
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasSuffix(p, "/blobs/uploads/session") && r.Method == "PUT":
+		b, _ := ioutil.ReadAll(r.Body)
+		if digest := r.URL.Query().Get("digest"); digest != digestOf(b) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reg.blobs[digestOf(b)] = b
+		reg.uploads++
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(p, "/blobs/"):
+		b, ok := reg.blobs[p[strings.LastIndex(p, "/")+1:]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		if r.Method == "GET" {
+			w.Write(b)
+		}
+	case strings.Contains(p, "/manifests/"):
+		i := strings.Index(p, "/manifests/")
+		reg.serveManifest(w, r, p[len("/v2/"):i], p[i+len("/manifests/"):])
+	case strings.HasSuffix(p, "/tags/list"):
+		reg.serveTags(w, r, strings.TrimSuffix(p[len("/v2/"):], "/tags/list"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (reg *testRegistry) serveManifest(w http.ResponseWriter, r *http.Request, repo string, ref string) {
+	switch r.Method {
+	case "PUT":
+		b, _ := ioutil.ReadAll(r.Body)
+		reg.storeManifest(repo, r.Header.Get("Content-Type"), b, ref)
+		w.Header().Set("Docker-Content-Digest", digestOf(b))
+		w.WriteHeader(http.StatusCreated)
+	case "GET", "HEAD":
+		b, ok := reg.manifests[repo][ref]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !reg.NoDigestHeader {
+			w.Header().Set("Docker-Content-Digest", digestOf(b))
+		}
+		w.Header().Set("Content-Type", reg.types[digestOf(b)])
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		if r.Method == "GET" {
+			w.Write(b)
+		}
+	case "DELETE":
+		_, ok := reg.manifests[repo][ref]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// like GCR, a manifest is deleted by digest only once no tag points at it
+		if strings.HasPrefix(ref, "sha256:") {
+			for k, v := range reg.manifests[repo] {
+				if k != ref && digestOf(v) == ref {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprintf(w, `{"errors":[{"code":"GOOGLE_MANIFEST_DANGLING_TAG","message":"manifest %v is tagged %v"}]}`, ref, k)
+					return
+				}
+			}
+		}
+		delete(reg.manifests[repo], ref)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// serveTags lists tags in order, PageSize at a time with a Link to the next page
+func (reg *testRegistry) serveTags(w http.ResponseWriter, r *http.Request, repo string) {
+	if reg.manifests[repo] == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var tags []string
+	last := r.URL.Query().Get("last")
+	for ref := range reg.manifests[repo] {
+		if !strings.HasPrefix(ref, "sha256:") && ref > last {
+			tags = append(tags, ref)
+		}
+	}
+	sort.Strings(tags)
+
+	if reg.PageSize > 0 && len(tags) > reg.PageSize {
+		tags = tags[:reg.PageSize]
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%v/tags/list?n=%d&last=%v>; rel="next"`, repo, reg.PageSize, tags[len(tags)-1]))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": repo, "tags": tags})
+}
+
+// writeTestLayout writes a single layer OCI image layout to dir, returning the manifest digest
+func writeTestLayout(t *testing.T, dir string, layer string) string {
+	t.Helper()
+
+	put := func(mediaType string, b []byte) Descriptor {
+		d := Descriptor{MediaType: mediaType, Digest: digestOf(b), Size: int64(len(b))}
+		path, _ := layoutBlobPath(dir, d.Digest)
+		writeTestFile(t, path, string(b))
+		return d
+	}
+	config := put(MediaTypeOCIConfig, []byte(`{"architecture":"amd64","os":"linux"}`))
+	m, _ := json.Marshal(Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIManifest, Config: config, Layers: []Descriptor{put(MediaTypeOCILayer, []byte(layer))}})
+	index, _ := json.Marshal(Index{SchemaVersion: 2, Manifests: []Descriptor{put(MediaTypeOCIManifest, m)}})
+
+	writeTestFile(t, filepath.Join(dir, "index.json"), string(index))
+	writeTestFile(t, filepath.Join(dir, "oci-layout"), `{"imageLayoutVersion":"1.0.0"}`)
+	return digestOf(m)
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,6 +1,41 @@
 package cicd
 
+import (
+	"log"
+	"os"
+)
+
 type Travis struct {
 	Name string
 	Plan string
 }
+
+// BuildInfo reads the TRAVIS_* environment.  Tag builds are reported as the tag event, cron and api
+// builds as push.  Outside travis nothing is detected and flags must be given
+func (t *Travis) BuildInfo() (bi BuildInfo, err error) {
+	if os.Getenv("TRAVIS") != "true" {
+		log.Println("travis: not running under travis ci; using flags")
+		return bi, err
+	}
+
+	bi.Branch = os.Getenv("TRAVIS_BRANCH")
+	bi.Commit = os.Getenv("TRAVIS_COMMIT")
+	bi.Build = os.Getenv("TRAVIS_BUILD_NUMBER")
+	bi.Tag = os.Getenv("TRAVIS_TAG")
+
+	switch {
+	case bi.Tag != "":
+		bi.Event = "tag"
+	case os.Getenv("TRAVIS_EVENT_TYPE") == "pull_request":
+		bi.Event = "pull_request"
+		bi.PR = os.Getenv("TRAVIS_PULL_REQUEST")
+
+		// TRAVIS_BRANCH is the target of a pull request; use the branch being merged
+		if b := os.Getenv("TRAVIS_PULL_REQUEST_BRANCH"); b != "" {
+			bi.Branch = b
+		}
+	default:
+		bi.Event = "push"
+	}
+	return bi, err
+}
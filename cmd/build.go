@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var buildArgs []string
+
+// buildCmd represents the build command
+var buildCmd = &cobra.Command{
+	Use:           "build",
+	Short:         "build the application image with traceability labels",
+	Long:          "build the application image from the Dockerfile and context in cicd.yaml, labeled with source, revision, created time, branch and version",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE:          buildImage,
+}
+
+func init() {
+	buildCmd.Flags().StringVarP(&branch, "branch", "b", "", "branch name for labels (default is detected by the CI provider)")
+	buildCmd.Flags().StringVarP(&baseImage, "image", "i", "", "image name and tag to build (default is <app name>:<commit>)")
+	buildCmd.Flags().StringVarP(&commit, "commit", "", "", "commit sha for labels and the default image tag (default is detected by the CI provider)")
+	buildCmd.Flags().StringVarP(&version, "version", "", "", "version label (default is the git tag detected by the CI provider)")
+	buildCmd.Flags().StringArrayVarP(&buildArgs, "build-arg", "", nil, "KEY=VALUE build arg, overriding app build args in cicd.yaml (repeatable)")
+
+	RootCmd.AddCommand(buildCmd)
+
+}
+
+func buildImage(ccmd *cobra.Command, args []string) (err error) {
+
+	// populate runtime options from flags
+	wf.Options.Branch = branch
+	wf.Options.Image = baseImage
+	wf.Options.Commit = commit
+	wf.Options.Version = version
+	wf.Options.BuildArgs = buildArgs
+
+	_, err = wf.Build()
+	return err
+}
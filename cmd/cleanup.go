@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// cleanupCmd represents the cleanup command
+var cleanupCmd = &cobra.Command{
+	Use:           "cleanup",
+	Short:         "delete registry tags according to retention rules",
+	Long:          "delete tags in the active registry according to the retention rules in cicd.yaml, never deleting tags deployed to the active platform; use --dryrun for a report only",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE:          cleanup,
+}
+
+func init() {
+	RootCmd.AddCommand(cleanupCmd)
+}
+
+func cleanup(ccmd *cobra.Command, args []string) (err error) {
+	_, err = wf.Cleanup()
+	return err
+}
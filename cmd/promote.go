@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var promoteFrom, promoteTo, promoteRepo string
+var envTags []string
+
+// promoteCmd represents the promote command
+var promoteCmd = &cobra.Command{
+	Use:           "promote",
+	Short:         "copy a pushed image between registries and apply environment tags",
+	Long:          "copy a pushed image by tag or digest from one configured registry to another, registry to registry without a local pull, and apply environment tags",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE:          promote,
+}
+
+func init() {
+	promoteCmd.Flags().StringVarP(&promoteFrom, "from", "", "", "registry id to copy from, e.g. docker (required)")
+	promoteCmd.Flags().StringVarP(&promoteTo, "to", "", "", "registry id to copy to, e.g. gcr (required)")
+	promoteCmd.Flags().StringVarP(&promoteRepo, "to-repo", "", "", "repository to copy to in the --to registry, in place of its configured url, e.g. docker.io/team/app-prod")
+	promoteCmd.Flags().StringVarP(&buildTag, "tag", "t", "", "image tag to promote; also applied in the destination")
+	promoteCmd.Flags().StringVarP(&digest, "digest", "", "", "image digest (sha256:...) to promote, verified against --tag when both are given")
+	promoteCmd.Flags().StringArrayVarP(&envTags, "env", "", nil, "environment tag to apply, e.g. staging or prod (repeatable)")
+	promoteCmd.Flags().StringVarP(&resultFile, "result-file", "", "", "write promoted image digests as json for use by deploy --push-result")
+
+	RootCmd.AddCommand(promoteCmd)
+
+}
+
+func promote(ccmd *cobra.Command, args []string) (err error) {
+
+	// populate runtime options from flags
+	wf.Options.PromoteFrom = promoteFrom
+	wf.Options.PromoteTo = promoteTo
+	wf.Options.PromoteRepo = promoteRepo
+	wf.Options.Tag = buildTag
+	wf.Options.Digest = digest
+	wf.Options.EnvTags = envTags
+	wf.Options.ResultFile = resultFile
+
+	_, err = wf.Promote()
+	return err
+}
@@ -1,18 +1,12 @@
 package cmd
 
 import (
-	"bytes"
-	"fmt"
-	"log"
-	"os/exec"
-	"strings"
-
-	"github.com/markTward/gocloud-cicd/cicd"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
-var event, baseImage, pr string
+var event, baseImage, pr, source, resultFile, commit, build, version string
+var latest, force bool
+var platformImages []string
 
 // pushCmd represents the push command
 var pushCmd = &cobra.Command{
@@ -25,10 +19,18 @@ var pushCmd = &cobra.Command{
 }
 
 func init() {
-	pushCmd.Flags().StringVarP(&branch, "branch", "b", "", "branch name for tagging")
-	pushCmd.Flags().StringVarP(&event, "event", "e", "push", "build event type from list: push, pull_request")
+	pushCmd.Flags().StringVarP(&branch, "branch", "b", "", "branch name for tagging (default is detected by the CI provider)")
+	pushCmd.Flags().StringVarP(&event, "event", "e", "", "build event type from list: push, pull_request, tag, release (default is detected by the CI provider, else push)")
 	pushCmd.Flags().StringVarP(&baseImage, "image", "i", "", "built image used as basis for tagging (required)")
-	pushCmd.Flags().StringVarP(&pr, "pr", "", "", "pull request number (required when event type is pull_request)")
+	pushCmd.Flags().StringVarP(&pr, "pr", "", "", "pull request number (required when event type is pull_request; default is detected by the CI provider)")
+	pushCmd.Flags().StringVarP(&commit, "commit", "", "", "commit sha for tag templates (default is detected by the CI provider, else the --image tag)")
+	pushCmd.Flags().StringVarP(&build, "build", "", "", "ci build number for tag templates (default is detected by the CI provider)")
+	pushCmd.Flags().StringVarP(&version, "version", "", "", "semantic version for tag templates; the git tag, e.g. v1.4.2, for release events")
+	pushCmd.Flags().BoolVarP(&latest, "latest", "", false, "also tag a release event as latest")
+	pushCmd.Flags().StringVarP(&resultFile, "result-file", "", "", "write pushed image digests as json for use by deploy --push-result")
+	pushCmd.Flags().BoolVarP(&force, "force", "", false, "push even when the local git working tree has uncommitted changes")
+	pushCmd.Flags().StringArrayVarP(&platformImages, "platform-image", "", nil, "<os>/<arch>[/<variant>]=<image> per-platform image (or oci registry source) published as a multi-arch index (repeatable)")
+	pushCmd.Flags().StringVarP(&source, "source", "", "", "OCI layout directory or image tarball (required by oci registry)")
 
 	RootCmd.AddCommand(pushCmd)
 
@@ -36,107 +38,20 @@ func init() {
 
 func push(ccmd *cobra.Command, args []string) (err error) {
 
-	// validate args
-	if err := validatePushArgs(); err != nil {
-		return err
-	}
-
-	// initialize active Registry indicated by config and assert as Registrator
-	var activeRegistry interface{}
-	if activeRegistry, err = wf.GetActiveRegistry(); err != nil {
-		return err
-	}
-	ar := activeRegistry.(cicd.Registrator)
-
-	// validate registry has required values
-	if err := ar.IsRegistryValid(); err != nil {
-		return err
-	}
-
-	// authenticate credentials for registry
-	if err := ar.Authenticate(); err != nil {
-		return err
-	}
-
-	// make list of images to tag
-	var images []string
-	if images = makeTagList(ar.GetRepoURL()); len(images) == 0 {
-		return fmt.Errorf("no images to tag: %v", images)
-	}
-
-	// tag images
-	if err = tagImages(images); err != nil {
-		return err
-	}
-	log.Println("tagged images:", images)
-
-	// push tagged images
-	var result []string
-	if result, err = ar.Push(images); err != nil {
-		return err
-	}
-	log.Println("pushed images:", result)
-	return err
-}
-
-// TODO: the case keys are travis specific.  add as method for CI provider
-func makeTagList(repoURL string) (images []string) {
-
-	// tag additional images based on build event type
-	tagSep := strings.Index(baseImage, ":")
-	commitImage := repoURL + baseImage[tagSep:]
-
-	images = append(images, commitImage)
-
-	switch event {
-	case "push":
-		images = append(images, repoURL+":"+branch)
-		if branch == "master" {
-			images = append(images, repoURL+":latest")
-		}
-	case "pull_request":
-		images = append(images, repoURL+":PR-"+pr)
-	}
-
-	return images
-}
-
-func tagImages(images []string) (err error) {
-	var stderr bytes.Buffer
-
-	for _, image := range images {
-		cmd := exec.Command("docker", "tag", baseImage, image)
-		cmd.Stderr = &stderr
-
-		log.Println(viper.GetString("cmdMode"), strings.Join(cmd.Args, " "))
-
-		if !cicd.IsDryRun() {
-			if err = cmd.Run(); err != nil {
-				err = fmt.Errorf("%v", stderr.String())
-				break
-			}
-		}
-	}
-
-	return err
-}
-
-// TODO: the event case keys are travis specific.  add as method for CI provider
-func validatePushArgs() (err error) {
-
-	switch {
-	case baseImage == "":
-		err = fmt.Errorf("%v", "build image a required value; use --image option")
-
-	case branch == "":
-		err = fmt.Errorf("%v", "build branch a required value; use --branch option")
-
-	case !(event == "push" || event == "pull_request"):
-		err = fmt.Errorf("%v", "event type must be one of: push, pull_request")
-
-	case event == "pull_request" && pr == "":
-		err = fmt.Errorf("%v", "event type pull_request requires a PR number; use --pr option")
-	}
+	// populate runtime options from flags
+	wf.Options.Branch = branch
+	wf.Options.Event = event
+	wf.Options.Image = baseImage
+	wf.Options.PR = pr
+	wf.Options.Source = source
+	wf.Options.ResultFile = resultFile
+	wf.Options.Force = force
+	wf.Options.Platforms = platformImages
+	wf.Options.Commit = commit
+	wf.Options.Build = build
+	wf.Options.Version = version
+	wf.Options.Latest = latest
+
+	_, err = wf.Push()
 	return err
-
 }
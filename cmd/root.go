@@ -62,29 +62,25 @@ func initConfig() {
 		log.Fatalf("unable to read config file: %v", err)
 	}
 
-	// override default config file settings when flag provided
-	viper.SetDefault("isDebug", wf.Config.Debug)
-	if RootCmd.PersistentFlags().Lookup("debug").Changed {
-		viper.BindPFlag("isDebug", RootCmd.PersistentFlags().Lookup("debug"))
+	// seed runtime options from config file, then override when flag provided
+	wf.Init()
+	if f := RootCmd.PersistentFlags().Lookup("debug"); f.Changed {
+		wf.Options.Debug, _ = RootCmd.PersistentFlags().GetBool("debug")
 	}
 
-	viper.SetDefault("isDryRun", wf.Config.Dryrun)
-	if RootCmd.PersistentFlags().Lookup("dryrun").Changed {
-		viper.BindPFlag("isDryRun", RootCmd.PersistentFlags().Lookup("dryrun"))
+	if f := RootCmd.PersistentFlags().Lookup("dryrun"); f.Changed {
+		wf.Options.DryRun, _ = RootCmd.PersistentFlags().GetBool("dryrun")
 	}
 
-	// set cmdMode as logging prefix for action-oriented commands
-	viper.SetDefault("cmdMode", "execute:")
-	if viper.GetBool("isDryRun") {
-		viper.Set("cmdMode", "dryrun:")
+	// broadcast global settigns
+	if wf.IsDryRun() {
 		log.Println("operating in dryrun mode")
 	}
 
-	// broadcast global settigns
-	if viper.GetBool("isDebug") {
+	if wf.IsDebug() {
 		log.Println("operating in debug mode")
 	}
 
-	cicd.LogDebug(fmt.Sprintf("Config: %v", spew.Sdump(wf)))
+	wf.LogDebug(fmt.Sprintf("Config: %v", spew.Sdump(wf)))
 
 }